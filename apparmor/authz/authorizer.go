@@ -0,0 +1,20 @@
+//go:build linux
+package authz
+
+import (
+	"fmt"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+)
+
+// Authorizer returns an apparmor.PeerAuthorizer that decides peers requesting action
+// with the policy, for use with apparmor.LabelListener and the peer package.
+func (p *Policy) Authorizer(action string) apparmor.PeerAuthorizer {
+	return apparmor.PeerAuthorizerFunc(func(label apparmor.Label, mode apparmor.Mode) error {
+		req := Request{Label: label, Mode: mode, Action: action}
+		if d := p.Evaluate(req); !d.Allowed() {
+			return fmt.Errorf("%w: %s: %s", apparmor.ErrPeerDenied, req, d)
+		}
+		return nil
+	})
+}
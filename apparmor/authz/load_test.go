@@ -0,0 +1,86 @@
+package authz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicyYAML = `
+algorithm: deny-overrides
+default: deny
+rules:
+  - name: read
+    profile: "test-*"
+    mode: enforce
+    actions: [read, list]
+    effect: allow
+  - name: deny-hat
+    label: "*//*"
+    effect: deny
+`
+
+const testPolicyJSON = `{
+	"algorithm": "deny-overrides",
+	"default": "deny",
+	"rules": [
+		{"name": "read", "profile": "test-*", "mode": "enforce", "actions": ["read", "list"], "effect": "allow"},
+		{"name": "deny-hat", "label": "*//*", "effect": "deny"}
+	]
+}`
+
+func TestParse(t *testing.T) {
+	expected := &Policy{
+		Algorithm: DenyOverrides,
+		Default:   Deny,
+		Rules: []Rule{
+			{Name: "read", Profile: "test-*", Mode: "enforce", Actions: []string{"read", "list"}, Effect: Allow},
+			{Name: "deny-hat", Label: "*//*", Effect: Deny},
+		},
+	}
+
+	p, err := ParseYAML([]byte(testPolicyYAML))
+	require.NoError(t, err)
+	assert.Equal(t, expected, p)
+
+	p, err = ParseJSON([]byte(testPolicyJSON))
+	require.NoError(t, err)
+	assert.Equal(t, expected, p)
+
+	_, err = ParseYAML([]byte("rules:\n  - lable: x\n    effect: allow\n"))
+	assert.Error(t, err)
+	_, err = ParseJSON([]byte(`{"rules": [{"lable": "x", "effect": "allow"}]}`))
+	assert.Error(t, err)
+	_, err = ParseJSON([]byte(`{"rules": [{"effect": "permit"}]}`))
+	assert.Error(t, err)
+
+	p, err = ParseYAML(nil)
+	require.NoError(t, err)
+	assert.Empty(t, p.Rules)
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"policy.yaml": testPolicyYAML,
+		"policy.yml":  testPolicyYAML,
+		"policy.json": testPolicyJSON,
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		p, err := LoadFile(path)
+		require.NoError(t, err, name)
+		assert.Len(t, p.Rules, 2)
+	}
+
+	path := filepath.Join(dir, "policy.toml")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	_, err := LoadFile(path)
+	assert.Error(t, err)
+
+	_, err = LoadFile(filepath.Join(dir, "nonexistent.json"))
+	assert.True(t, os.IsNotExist(err))
+}
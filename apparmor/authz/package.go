@@ -0,0 +1,3 @@
+// Package authz decides access by AppArmor labels, such as the peer label of a
+// connection or the label of a task, using declarative rules.
+package authz
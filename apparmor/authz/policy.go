@@ -0,0 +1,250 @@
+//go:build linux
+package authz
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+	"github.com/eternal-flame-AD/go-apparmor/internal/glob"
+)
+
+// Effect is the outcome of a rule.
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Algorithm is the way rules are combined into a decision.
+type Algorithm string
+
+const (
+	// FirstMatch decides by the first rule matching the request, in order.
+	FirstMatch Algorithm = "first-match"
+	// DenyOverrides denies if any matching rule denies, and allows if any matching rule allows.
+	DenyOverrides Algorithm = "deny-overrides"
+)
+
+// Rule matches requests by globs and decides their effect, see [glob] for the syntax.
+// An empty field matches anything.
+type Rule struct {
+	// Name identifies the rule in decisions, the index of the rule is used if empty
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// Label is matched against the full label, e.g. "test-profile//*"
+	Label string `json:"label,omitempty" yaml:"label,omitempty"`
+	// Mode is matched against the mode, e.g. "enforce"
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+
+	// Namespace, Profile and Component must match the same stack component of the label.
+	// Namespace is matched against its namespace, which is empty for the current namespace
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Profile is matched against its top level profile
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	// Component is matched against its full name without namespace, e.g. "test-profile//test-hat"
+	Component string `json:"component,omitempty" yaml:"component,omitempty"`
+
+	// Actions are matched against the requested action, any action matches if empty
+	Actions []string `json:"actions,omitempty" yaml:"actions,omitempty"`
+	// Effect is the effect of the rule if it matches
+	Effect Effect `json:"effect" yaml:"effect"`
+}
+
+// Policy is a set of rules.
+type Policy struct {
+	// Algorithm defaults to FirstMatch
+	Algorithm Algorithm `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	// Default is the effect if no rule matches, defaults to Deny
+	Default Effect `json:"default,omitempty" yaml:"default,omitempty"`
+	Rules   []Rule `json:"rules" yaml:"rules"`
+}
+
+// Request is a request for access by a label.
+type Request struct {
+	Label  apparmor.Label
+	Mode   apparmor.Mode
+	Action string
+}
+
+// NewRequest returns a Request for the label and mode as returned by
+// AAGetPeerCon() or AAGetTaskCon(), the mode is parsed like SplitConMode().
+func NewRequest(label string, mode string, action string) (Request, error) {
+	rawLabel, m, err := apparmor.SplitConMode(apparmor.FormatCon(label, apparmor.ParseMode(mode)))
+	if err != nil {
+		return Request{}, err
+	}
+	parsed, err := apparmor.ParseLabel(rawLabel)
+	if err != nil {
+		return Request{}, err
+	}
+	return Request{Label: parsed, Mode: m, Action: action}, nil
+}
+
+func (r Request) String() string {
+	return fmt.Sprintf("%q %s", apparmor.FormatCon(r.Label.String(), r.Mode), r.Action)
+}
+
+// TraceStep records the evaluation of one rule.
+type TraceStep struct {
+	Rule    int
+	Name    string
+	Matched bool
+	// Reason is the first condition that did not match, empty if the rule matched
+	Reason string
+}
+
+// Decision is the result of evaluating a request against a policy.
+type Decision struct {
+	Effect Effect
+	// Rule is the index of the deciding rule, -1 if the default effect applied
+	Rule int
+	// Name is the name of the deciding rule
+	Name string
+	// Trace records every rule evaluated, only set by Policy.Explain()
+	Trace []TraceStep
+}
+
+// Allowed returns if the decision allows the request.
+func (d Decision) Allowed() bool {
+	return d.Effect == Allow
+}
+
+func (d Decision) String() string {
+	if d.Rule < 0 {
+		return fmt.Sprintf("%s by default", d.Effect)
+	}
+	return fmt.Sprintf("%s by rule %s", d.Effect, d.Name)
+}
+
+// Validate checks the policy for unknown algorithms and effects and glob syntax errors.
+func (p *Policy) Validate() error {
+	switch p.Algorithm {
+	case "", FirstMatch, DenyOverrides:
+	default:
+		return fmt.Errorf("unknown algorithm %q", p.Algorithm)
+	}
+	switch p.Default {
+	case "", Allow, Deny:
+	default:
+		return fmt.Errorf("unknown default effect %q", p.Default)
+	}
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %s: unknown effect %q", ruleName(i, r), r.Effect)
+		}
+		patterns := append([]string{r.Label, r.Mode, r.Namespace, r.Profile, r.Component}, r.Actions...)
+		for _, pattern := range patterns {
+			if err := glob.Validate(pattern); err != nil {
+				return fmt.Errorf("rule %s: invalid pattern %q: %v", ruleName(i, r), pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate decides req against the policy.
+func (p *Policy) Evaluate(req Request) Decision {
+	return p.evaluate(req, false)
+}
+
+// Explain is like Evaluate() but records the evaluation of every rule in Decision.Trace.
+func (p *Policy) Explain(req Request) Decision {
+	return p.evaluate(req, true)
+}
+
+func (p *Policy) evaluate(req Request, explain bool) Decision {
+	var trace []TraceStep
+	decided := -1
+	for i, r := range p.Rules {
+		reason := r.mismatch(req)
+		if explain {
+			trace = append(trace, TraceStep{Rule: i, Name: ruleName(i, r), Matched: reason == "", Reason: reason})
+		}
+		if reason != "" {
+			continue
+		}
+		if p.Algorithm == DenyOverrides {
+			// the first deny decides, otherwise the first allow
+			if r.Effect == Deny {
+				decided = i
+				break
+			} else if decided < 0 {
+				decided = i
+			}
+			continue
+		}
+		decided = i
+		break
+	}
+
+	d := Decision{Effect: Deny, Rule: -1, Trace: trace}
+	if decided >= 0 {
+		d.Effect = p.Rules[decided].Effect
+		d.Rule = decided
+		d.Name = ruleName(decided, p.Rules[decided])
+	} else if p.Default != "" {
+		d.Effect = p.Default
+	}
+	return d
+}
+
+// mismatch returns the first condition of the rule not matching req, or an empty string.
+func (r Rule) mismatch(req Request) string {
+	if r.Label != "" && !glob.Match(r.Label, req.Label.String()) {
+		return "label"
+	}
+	if r.Mode != "" && !glob.Match(r.Mode, req.Mode.String()) {
+		return "mode"
+	}
+	if r.Namespace != "" || r.Profile != "" || r.Component != "" {
+		found := false
+		for _, c := range req.Label.Components {
+			if (r.Namespace == "" || glob.Match(r.Namespace, c.Namespace)) &&
+				(r.Profile == "" || glob.Match(r.Profile, c.Profile())) &&
+				(r.Component == "" || glob.Match(r.Component, c.Name())) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "component"
+		}
+	}
+	if len(r.Actions) > 0 {
+		found := false
+		for _, action := range r.Actions {
+			if glob.Match(action, req.Action) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "action"
+		}
+	}
+	return ""
+}
+
+func ruleName(i int, r Rule) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// FormatTrace formats the trace of a decision returned by Policy.Explain() for humans,
+// one rule per line.
+func FormatTrace(d Decision) string {
+	var b strings.Builder
+	for _, step := range d.Trace {
+		if step.Matched {
+			fmt.Fprintf(&b, "rule %s: matched\n", step.Name)
+		} else {
+			fmt.Fprintf(&b, "rule %s: %s does not match\n", step.Name, step.Reason)
+		}
+	}
+	fmt.Fprintf(&b, "%s\n", d)
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustRequest(t *testing.T, label string, mode string, action string) Request {
+	req, err := NewRequest(label, mode, action)
+	require.NoError(t, err)
+	return req
+}
+
+var testRules = []Rule{
+	{Name: "deny-untrusted", Component: "test-profile//untrusted", Effect: Deny},
+	{Name: "read", Profile: "test-*", Mode: "enforce", Actions: []string{"read", "list"}, Effect: Allow},
+	{Name: "ns-write", Namespace: "ns", Actions: []string{"write"}, Effect: Allow},
+	{Name: "deny-complain", Mode: "complain", Effect: Deny},
+	{Profile: "test-profile", Effect: Allow},
+}
+
+func TestNewRequest(t *testing.T) {
+	req := mustRequest(t, "unconfined", "", "read")
+	assert.Equal(t, apparmor.ModeUnconfined, req.Mode)
+	assert.Equal(t, `"unconfined" read`, req.String())
+
+	req = mustRequest(t, "test-profile", "enforce", "read")
+	assert.Equal(t, apparmor.ModeEnforce, req.Mode)
+	assert.Equal(t, `"test-profile (enforce)" read`, req.String())
+
+	req = mustRequest(t, "unconfined", "unconfined", "read")
+	assert.Equal(t, apparmor.ModeUnconfined, req.Mode)
+
+	_, err := NewRequest("", "", "read")
+	assert.Error(t, err)
+	_, err = NewRequest("test-profile", "", "read")
+	assert.Error(t, err)
+}
+
+func TestEvaluateFirstMatch(t *testing.T) {
+	p := &Policy{Rules: testRules}
+	require.NoError(t, p.Validate())
+
+	d := p.Evaluate(mustRequest(t, "test-profile", "enforce", "read"))
+	assert.True(t, d.Allowed())
+	assert.Equal(t, 1, d.Rule)
+	assert.Equal(t, "allow by rule read", d.String())
+	assert.Nil(t, d.Trace)
+
+	d = p.Evaluate(mustRequest(t, "test-profile//untrusted", "enforce", "read"))
+	assert.False(t, d.Allowed())
+	assert.Equal(t, "deny-untrusted", d.Name)
+
+	d = p.Evaluate(mustRequest(t, "other//&:ns:daemon", "enforce", "write"))
+	assert.True(t, d.Allowed())
+	assert.Equal(t, "ns-write", d.Name)
+
+	// the last rule is shadowed by deny-complain
+	d = p.Evaluate(mustRequest(t, "test-profile", "complain", "write"))
+	assert.False(t, d.Allowed())
+	assert.Equal(t, "deny-complain", d.Name)
+
+	d = p.Evaluate(mustRequest(t, "test-profile//hat", "enforce", "write"))
+	assert.True(t, d.Allowed())
+	assert.Equal(t, "#4", d.Name)
+
+	d = p.Evaluate(mustRequest(t, "unconfined", "", "read"))
+	assert.False(t, d.Allowed())
+	assert.Equal(t, -1, d.Rule)
+	assert.Equal(t, "deny by default", d.String())
+
+	p.Default = Allow
+	assert.True(t, p.Evaluate(mustRequest(t, "unconfined", "", "read")).Allowed())
+}
+
+func TestEvaluateDenyOverrides(t *testing.T) {
+	p := &Policy{
+		Algorithm: DenyOverrides,
+		Rules: []Rule{
+			{Name: "allow-all", Effect: Allow},
+			{Name: "deny-write", Actions: []string{"write"}, Effect: Deny},
+			{Name: "deny-hat", Label: "*//*", Effect: Deny},
+		},
+	}
+	require.NoError(t, p.Validate())
+
+	d := p.Evaluate(mustRequest(t, "test-profile", "enforce", "read"))
+	assert.True(t, d.Allowed())
+	assert.Equal(t, "allow-all", d.Name)
+
+	d = p.Evaluate(mustRequest(t, "test-profile", "enforce", "write"))
+	assert.False(t, d.Allowed())
+	assert.Equal(t, "deny-write", d.Name)
+
+	d = p.Evaluate(mustRequest(t, "test-profile//hat", "enforce", "write"))
+	assert.Equal(t, "deny-write", d.Name)
+}
+
+func TestExplain(t *testing.T) {
+	p := &Policy{Rules: testRules}
+	d := p.Explain(mustRequest(t, "test-profile//hat", "enforce", "write"))
+	assert.Equal(t, []TraceStep{
+		{Rule: 0, Name: "deny-untrusted", Reason: "component"},
+		{Rule: 1, Name: "read", Reason: "action"},
+		{Rule: 2, Name: "ns-write", Reason: "component"},
+		{Rule: 3, Name: "deny-complain", Reason: "mode"},
+		{Rule: 4, Name: "#4", Matched: true},
+	}, d.Trace)
+	assert.Equal(t, `rule deny-untrusted: component does not match
+rule read: action does not match
+rule ns-write: component does not match
+rule deny-complain: mode does not match
+rule #4: matched
+allow by rule #4
+`, FormatTrace(d))
+}
+
+func TestValidate(t *testing.T) {
+	assert.Error(t, (&Policy{Algorithm: "random"}).Validate())
+	assert.Error(t, (&Policy{Default: "maybe"}).Validate())
+	assert.Error(t, (&Policy{Rules: []Rule{{}}}).Validate())
+	assert.Error(t, (&Policy{Rules: []Rule{{Label: `a\`, Effect: Allow}}}).Validate())
+	assert.Error(t, (&Policy{Rules: []Rule{{Actions: []string{`a\`}, Effect: Allow}}}).Validate())
+}
+
+func TestAuthorizer(t *testing.T) {
+	auth := (&Policy{Rules: testRules}).Authorizer("read")
+	req := mustRequest(t, "test-profile", "enforce", "")
+	assert.NoError(t, auth.AuthorizePeer(req.Label, req.Mode))
+
+	req = mustRequest(t, "test-profile//untrusted", "enforce", "")
+	err := auth.AuthorizePeer(req.Label, req.Mode)
+	assert.ErrorIs(t, err, apparmor.ErrPeerDenied)
+	assert.EqualError(t, err, `peer denied by policy: "test-profile//untrusted (enforce)" read: deny by rule deny-untrusted`)
+}
@@ -2,9 +2,11 @@
 package apparmor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
+	"runtime/debug"
 	"strings"
 )
 
@@ -28,21 +30,21 @@ func SetHat(hat string, magic uint64) error {
 		return nil
 	}
 
-	curLabel, _, err := AAGetCon()
+	curLabel, _, err := AAGetConLabel()
 	if err != nil {
 		return fmt.Errorf("cannot get current label: %v", err)
 	}
 
-	if !strings.HasSuffix(curLabel, "//"+hat) {
+	if !curLabel.InHat(hat) {
 		if err := AAChangeHat(hat, magic); err != nil {
 			return err
 		}
 
-		curLabel, _, err = AAGetCon()
+		curLabel, _, err = AAGetConLabel()
 		if err != nil {
 			return err
 		}
-		if !strings.HasSuffix(curLabel, "//"+hat) {
+		if !curLabel.InHat(hat) {
 			return fmt.Errorf("failed to change hat to %q", hat)
 		}
 	}
@@ -68,33 +70,219 @@ func SetHat(hat string, magic uint64) error {
 //
 // WithHat will return an error if the hat cannot be changed, fn() will not be executed.
 // If you want to leave a hat (i.e. pass in empty string), use SetHat() instead.
+//
+// If fn panics, the panic is recovered and returned as a *HatPanicError. The thread
+// fn ran on attempts to leave the hat to report the result in HatPanicError.ExitErr,
+// and is never reused afterwards.
 func WithHat(hat string, magic func() uint64, fn func()) error {
-	wait := make(chan error)
-
 	if hat == "" {
 		return errors.New("WithHat() does not accept empty string, are you trying to use SetHat()?")
 	}
 
+	return runInHat(hat, func() error {
+		return SetHat(hat, magic())
+	}, magic, fn)
+}
+
+// WithHatContext is like WithHat() but passes ctx to fn and returns the error
+// returned by fn.
+//
+// Failures to enter or leave the hat are returned as *HatError and can be told
+// apart with errors.Is(err, ErrHatEnter) and errors.Is(err, ErrHatExit).
+//
+// If ctx is done before fn returns, WithHatContext returns ctx.Err() immediately.
+// fn keeps running on its thread until it returns, after which the thread leaves
+// the hat as usual, so fn should observe ctx to return early.
+func WithHatContext(ctx context.Context, hat string, magic func() uint64, fn func(ctx context.Context) error) error {
+	_, err := WithHatValue(ctx, hat, magic, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// WithHatValue is like WithHatContext() but also returns the value returned by fn.
+func WithHatValue[T any](ctx context.Context, hat string, magic func() uint64, fn func(ctx context.Context) (T, error)) (T, error) {
+	if hat == "" {
+		var zero T
+		return zero, errors.New("WithHatValue() does not accept empty string, are you trying to use SetHat()?")
+	}
+
+	return runInHatValue(ctx, hat, func() error {
+		return SetHat(hat, magic())
+	}, magic, fn)
+}
+
+// SetHatAny is like SetHat() but enters the first hat in hats that exists in the
+// current profile, using AAChangeHatV(). It returns the hat that was entered.
+//
+// Like SetHat(), runtime.LockOSThread() is called regardless of the return value
+// once hats is found to be non-empty, and the caller is responsible for unlocking.
+//
+// If the kernel does not support change_hatv, only a single hat can be given,
+// ErrChangeHatVNotSupported is returned otherwise.
+func SetHatAny(hats []string, magic uint64) (string, error) {
+	if len(hats) == 0 {
+		return "", errors.New("SetHatAny() requires at least one hat, are you trying to use SetHat()?")
+	}
+	runtime.LockOSThread()
+
+	curLabel, _, err := AAGetConLabel()
+	if err != nil {
+		return "", fmt.Errorf("cannot get current label: %v", err)
+	}
+	for _, hat := range hats {
+		if curLabel.InHat(hat) {
+			return hat, nil
+		}
+	}
+
+	if err := AAChangeHatV(hats, magic); err != nil {
+		return "", err
+	}
+
+	curLabel, _, err = AAGetConLabel()
+	if err != nil {
+		return "", err
+	}
+	for _, hat := range hats {
+		if curLabel.InHat(hat) {
+			return hat, nil
+		}
+	}
+	return "", fmt.Errorf("failed to change hat to any of %q", hats)
+}
+
+// WithHatAny is like WithHat() but enters the first hat in hats that exists in the
+// current profile. The hat that was entered is passed to fn.
+func WithHatAny(hats []string, magic func() uint64, fn func(hat string)) error {
+	if len(hats) == 0 {
+		return errors.New("WithHatAny() requires at least one hat")
+	}
+
+	var entered string
+	return runInHat(strings.Join(hats, ","), func() (err error) {
+		entered, err = SetHatAny(hats, magic())
+		return err
+	}, magic, func() {
+		fn(entered)
+	})
+}
+
+// WithProfile spawns a new goroutine locked to its own thread, changes the profile of
+// that thread to profile using AAChangeProfile() and calls fn after verifying the new label.
+//
+// The transition is one-way, so the thread is never reused after fn returns and
+// is killed by the scheduler instead. Goroutines spawned by fn are not confined.
+//
+// WithProfile returns the error returned by fn, or an error if the profile cannot be
+// changed, in which case fn() will not be executed.
+// If fn panics, the panic is recovered and returned as a *ProfilePanicError.
+func WithProfile(profile string, fn func() error) error {
+	if profile == "" {
+		return errors.New("WithProfile() does not accept empty string")
+	}
+	return runInProfile(profile, AAChangeProfile, Label.Equal, fn)
+}
+
+// WithStack is like WithProfile() but stacks profile on top of the current
+// confinement using AAStackProfile().
+func WithStack(profile string, fn func() error) error {
+	if profile == "" {
+		return errors.New("WithStack() does not accept empty string")
+	}
+	return runInProfile(profile, AAStackProfile, Label.Contains, fn)
+}
+
+// runInProfile runs fn on a new goroutine locked to its own thread after transition
+// succeeds and check reports the new label matches profile. The thread is always retired.
+func runInProfile(profile string, transition func(string) error, check func(cur Label, target Label) bool, fn func() error) error {
+	target, err := ParseLabel(profile)
+	if err != nil {
+		return err
+	}
+
+	wait := make(chan error, 1)
 	go func() {
+		// never unlocked, the scheduler kills the thread when the goroutine returns
+		runtime.LockOSThread()
 		defer func() {
-			if err := recover(); err != nil {
-				if err, ok := err.(error); ok {
-					wait <- err
-					return
-				}
-				wait <- fmt.Errorf("panic: %v", err)
+			if r := recover(); r != nil {
+				wait <- &ProfilePanicError{Profile: profile, Value: r, Stack: debug.Stack()}
 			}
 		}()
 
-		if err := SetHat(hat, magic()); err != nil {
+		if err := transition(profile); err != nil {
 			wait <- err
 			return
 		}
+		curLabel, _, err := AAGetConLabel()
+		if err != nil {
+			wait <- fmt.Errorf("cannot get current label: %v", err)
+			return
+		}
+		if !check(curLabel, target) {
+			wait <- fmt.Errorf("failed to change profile to %q, current label is %q", profile, curLabel)
+			return
+		}
 
+		wait <- fn()
+	}()
+	return <-wait
+}
+
+// runInHat runs fn on a new goroutine locked to its own thread after enter
+// succeeds, and transitions back to the parent profile afterwards.
+func runInHat(hat string, enter func() error, magic func() uint64, fn func()) error {
+	_, err := runInHatValue(context.Background(), hat, enter, magic, func(context.Context) (struct{}, error) {
 		fn()
+		return struct{}{}, nil
+	})
+	return err
+}
 
-		if err := SetHat("", magic()); err != nil {
-			wait <- err
+func runInHatValue[T any](ctx context.Context, hat string, enter func() error, magic func() uint64, fn func(ctx context.Context) (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	var zero T
+
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	// buffered so that the goroutine can finish even if the caller gave up on ctx
+	wait := make(chan result, 1)
+
+	go func() {
+		entered := false
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if !entered {
+				// the thread is left locked in an unknown state, let the scheduler kill it
+				wait <- result{err: &HatError{Hat: hat, Err: recoveredError(r)}}
+				return
+			}
+			panicErr := &HatPanicError{Hat: hat, Value: r, Stack: debug.Stack()}
+			// try to leave the hat to report whether the thread was still in a sane state,
+			// but never reuse a thread fn panicked on
+			panicErr.ExitErr = leaveHat(magic)
+			wait <- result{err: panicErr}
+		}()
+
+		if err := enter(); err != nil {
+			wait <- result{err: &HatError{Hat: hat, Err: err}}
+			return
+		}
+		entered = true
+
+		value, err := fn(ctx)
+
+		if exitErr := leaveHat(magic); exitErr != nil {
+			wait <- result{err: &HatError{Hat: hat, Exit: true, Err: exitErr}}
 			return
 		}
 		// only if we transitioned back successfully could we reuse this thread
@@ -102,8 +290,32 @@ func WithHat(hat string, magic func() uint64, fn func()) error {
 		runtime.UnlockOSThread()
 		// call twice because we called SetHat() twice
 		runtime.UnlockOSThread()
-		wait <- nil
+		wait <- result{value: value, err: err}
+	}()
+
+	select {
+	case res := <-wait:
+		return res.value, res.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
 
+// leaveHat transitions the current thread back to the parent profile,
+// returning a panic in magic as an error.
+func leaveHat(magic func() uint64) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+		}
 	}()
-	return <-wait
+	return SetHat("", magic())
+}
+
+// recoveredError converts a recovered panic value to an error.
+func recoveredError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("panic: %v", r)
 }
@@ -0,0 +1,146 @@
+package apparmor
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHatContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := WithHatContext(ctx, "test-hat", testMagic, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, called)
+}
+
+func TestWithHatContextEnterFailure(t *testing.T) {
+	called := false
+	value, err := WithHatValue(context.Background(), "go-apparmor-nonexistent-hat", testMagic, func(ctx context.Context) (int, error) {
+		called = true
+		return 1, nil
+	})
+	assert.ErrorIs(t, err, ErrHatEnter)
+	assert.NotErrorIs(t, err, ErrHatExit)
+	assert.Equal(t, 0, value)
+	assert.False(t, called)
+
+	_, err = WithHatValue(context.Background(), "", testMagic, func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	assert.Error(t, err)
+}
+
+func TestSetHatAnyInvalid(t *testing.T) {
+	_, err := SetHatAny(nil, testMagic())
+	assert.Error(t, err)
+	assert.Error(t, WithHatAny(nil, testMagic, func(string) {}))
+}
+
+func TestHatErrorIs(t *testing.T) {
+	cause := errors.New("cause")
+	enterErr := &HatError{Hat: "test-hat", Err: cause}
+	exitErr := &HatError{Hat: "test-hat", Exit: true, Err: cause}
+
+	assert.ErrorIs(t, enterErr, ErrHatEnter)
+	assert.NotErrorIs(t, enterErr, ErrHatExit)
+	assert.ErrorIs(t, enterErr, cause)
+	assert.ErrorIs(t, exitErr, ErrHatExit)
+	assert.NotErrorIs(t, exitErr, ErrHatEnter)
+	assert.Equal(t, `cannot leave hat "test-hat": cause`, exitErr.Error())
+}
+
+func TestRunInHatPanic(t *testing.T) {
+	// pretend to enter the hat, and fail to leave it by panicking in magic
+	enter := func() error {
+		runtime.LockOSThread()
+		return nil
+	}
+	magic := func() uint64 {
+		panic("magic")
+	}
+	cause := errors.New("cause")
+
+	_, err := runInHatValue(context.Background(), "test-hat", enter, magic, func(ctx context.Context) (int, error) {
+		panic(cause)
+	})
+	var panicErr *HatPanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "test-hat", panicErr.Hat)
+	assert.Equal(t, cause, panicErr.Value)
+	assert.ErrorIs(t, err, cause)
+	assert.Contains(t, string(panicErr.Stack), "TestRunInHatPanic")
+	assert.EqualError(t, panicErr.ExitErr, "panic: magic")
+	assert.ErrorIs(t, err, ErrHatExit)
+	assert.PanicsWithValue(t, cause, panicErr.Repanic)
+
+	// panics while entering are reported as enter failures
+	_, err = runInHatValue(context.Background(), "test-hat", func() error {
+		panic("enter")
+	}, testMagic, func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	assert.ErrorIs(t, err, ErrHatEnter)
+	assert.False(t, errors.As(err, &panicErr))
+}
+
+func TestHatPanicErrorIs(t *testing.T) {
+	err := &HatPanicError{Hat: "test-hat", Value: "test"}
+	assert.NotErrorIs(t, err, ErrHatExit)
+	assert.Nil(t, err.Unwrap())
+	assert.Equal(t, `panic in hat "test-hat": test`, err.Error())
+
+	err.ExitErr = errors.New("cause")
+	assert.ErrorIs(t, err, ErrHatExit)
+	assert.Equal(t, `panic in hat "test-hat": test (cannot leave hat "test-hat": cause)`, err.Error())
+}
+
+func TestProfilePanicError(t *testing.T) {
+	cause := errors.New("cause")
+	err := &ProfilePanicError{Profile: "test-profile", Value: cause}
+	assert.ErrorIs(t, err, cause)
+	assert.NotErrorIs(t, err, ErrHatExit)
+	assert.Equal(t, `panic in profile "test-profile": cause`, err.Error())
+}
+
+func TestRunInProfilePanic(t *testing.T) {
+	if _, _, err := AAGetConLabel(); err != nil {
+		t.Skipf("cannot get current label: %v", err)
+	}
+	err := runInProfile("test-profile", func(string) error { return nil },
+		func(Label, Label) bool { return true }, func() error { panic("test") })
+	var panicErr *ProfilePanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "test", panicErr.Value)
+	assert.NotEmpty(t, panicErr.Stack)
+	assert.EqualError(t, err, `panic in profile "test-profile": test`)
+	assert.False(t, errors.As(err, new(*HatPanicError)))
+
+	cause := errors.New("cause")
+	err = runInProfile("test-profile", func(string) error { return nil },
+		func(Label, Label) bool { return true }, func() error { panic(cause) })
+	assert.ErrorIs(t, err, cause)
+}
+
+func TestWithProfileFailure(t *testing.T) {
+	called := false
+	fn := func() error {
+		called = true
+		return nil
+	}
+
+	assert.Error(t, WithProfile("", fn))
+	assert.Error(t, WithStack("", fn))
+	assert.Error(t, WithProfile("go-apparmor-nonexistent-profile", fn))
+	assert.Error(t, WithStack("go-apparmor-nonexistent-profile", fn))
+	assert.False(t, called)
+}
@@ -0,0 +1,159 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// ProfileCmd is an external command started under an AppArmor profile.
+//
+// The underlying *exec.Cmd is not exposed, so the command cannot be started with the
+// confinement of the caller by mistake. The exported fields are copied to it by Start(),
+// see exec.Cmd for their meaning.
+type ProfileCmd struct {
+	Env         []string
+	Dir         string
+	Stdin       io.Reader
+	Stdout      io.Writer
+	Stderr      io.Writer
+	ExtraFiles  []*os.File
+	SysProcAttr *syscall.SysProcAttr
+
+	cmd     *exec.Cmd
+	profile string
+	stack   bool
+}
+
+// CommandWithProfile returns a command like exec.Command() that is started
+// under profile, as if AAChangeOnExec() was called right before exec.
+func CommandWithProfile(profile string, name string, arg ...string) *ProfileCmd {
+	return &ProfileCmd{cmd: exec.Command(name, arg...), profile: profile}
+}
+
+// CommandWithStack is like CommandWithProfile() but the command is started with profile
+// stacked on top of the confinement of the caller, as if AAStackOnExec() was called
+// right before exec.
+func CommandWithStack(profile string, name string, arg ...string) *ProfileCmd {
+	return &ProfileCmd{cmd: exec.Command(name, arg...), profile: profile, stack: true}
+}
+
+// Start starts the command like exec.Cmd.Start() under the profile.
+//
+// The exec attr is per-thread, so the command is started on a new goroutine locked to
+// its own thread after setting the exec attr. The attr cannot be cleared and would be
+// inherited by threads created from it, so the thread is never reused.
+func (c *ProfileCmd) Start() error {
+	if c.profile == "" {
+		return errors.New("ProfileCmd requires a profile")
+	}
+	c.cmd.Env = c.Env
+	c.cmd.Dir = c.Dir
+	c.cmd.Stdin = c.Stdin
+	c.cmd.Stdout = c.Stdout
+	c.cmd.Stderr = c.Stderr
+	c.cmd.ExtraFiles = c.ExtraFiles
+	c.cmd.SysProcAttr = c.SysProcAttr
+
+	wait := make(chan error, 1)
+	go func() {
+		// never unlocked, the scheduler kills the thread when the goroutine returns
+		runtime.LockOSThread()
+		var err error
+		if c.stack {
+			err = AAStackOnExec(c.profile)
+		} else {
+			err = AAChangeOnExec(c.profile)
+		}
+		if err != nil {
+			wait <- err
+			return
+		}
+		wait <- c.cmd.Start()
+	}()
+	return <-wait
+}
+
+// Run starts the command under the profile and waits for it to complete, like exec.Cmd.Run().
+func (c *ProfileCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Wait waits for the started command to exit, like exec.Cmd.Wait().
+func (c *ProfileCmd) Wait() error {
+	return c.cmd.Wait()
+}
+
+// Process returns the started process, nil before Start().
+func (c *ProfileCmd) Process() *os.Process {
+	return c.cmd.Process
+}
+
+// ProcessState returns the state of the exited process, nil before Wait().
+func (c *ProfileCmd) ProcessState() *os.ProcessState {
+	return c.cmd.ProcessState
+}
+
+// String returns a description of the command, like exec.Cmd.String().
+func (c *ProfileCmd) String() string {
+	return c.cmd.String()
+}
+
+// ProcessCon is the confinement of a process started by CommandInHat().
+type ProcessCon struct {
+	Label Label
+	Mode  Mode
+	// Err is the error reading the label of the process, in which case Label and Mode
+	// are zero. The process is running regardless.
+	Err error
+}
+
+// CommandInHat starts cmd from a thread in hat, so that the process inherits the hat
+// like goroutines run with WithHat(), and returns the label and mode of the started process.
+//
+// The thread enters the hat, forks and executes cmd, and leaves the hat once cmd.Start()
+// returns. The returned label is read after exec and reflects any exec transition
+// of the hat. CommandInHat does not wait for the process, the caller is responsible
+// for calling cmd.Wait() as usual, from any goroutine.
+//
+// If an error is returned, no process is left running: failures to enter the hat or to
+// start cmd are returned as is, and if the thread cannot leave the hat after starting cmd,
+// the process is killed and reaped before the *HatError is returned.
+//
+// The label is read through a pidfd, which requires Linux 5.3. If it cannot be read,
+// for example with ENOSYS on older kernels, the error is reported in ProcessCon.Err
+// and the started process still has to be waited for.
+func CommandInHat(hat string, magic func() uint64, cmd *exec.Cmd) (ProcessCon, error) {
+	if hat == "" {
+		return ProcessCon{}, errors.New("CommandInHat() does not accept empty string")
+	}
+
+	var startErr error
+	err := WithHat(hat, magic, func() {
+		// the goroutine is locked to the hatted thread, which forks the process
+		startErr = cmd.Start()
+	})
+	if startErr != nil {
+		return ProcessCon{}, startErr
+	}
+	if err != nil {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		return ProcessCon{}, err
+	}
+
+	label, mode, err := processConLabel(cmd.Process)
+	if err != nil {
+		return ProcessCon{Err: err}, nil
+	}
+	return ProcessCon{Label: label, Mode: mode}, nil
+}
@@ -0,0 +1,28 @@
+package apparmor
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileCmdInvalid(t *testing.T) {
+	assert.Error(t, CommandWithProfile("", "true").Run())
+	assert.Error(t, CommandWithStack("", "true").Run())
+
+	cmd := CommandWithProfile("test-profile", "true", "arg")
+	assert.Nil(t, cmd.Process())
+	assert.Nil(t, cmd.ProcessState())
+	assert.Contains(t, cmd.String(), "true arg")
+}
+
+func TestCommandInHatEnterFailure(t *testing.T) {
+	cmd := exec.Command("true")
+	_, err := CommandInHat("go-apparmor-nonexistent-hat", testMagic, cmd)
+	assert.ErrorIs(t, err, ErrHatEnter)
+	assert.Nil(t, cmd.Process)
+
+	_, err = CommandInHat("", testMagic, cmd)
+	assert.Error(t, err)
+}
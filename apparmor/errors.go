@@ -0,0 +1,247 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrHatNotFound is returned when changing into a hat that does not exist in the current profile.
+	ErrHatNotFound = errors.New("hat not found")
+	// ErrBadMagic is returned when leaving or switching hats with the wrong magic token.
+	ErrBadMagic = errors.New("magic token mismatch")
+	// ErrUnconfined is returned when changing hats while unconfined.
+	ErrUnconfined = errors.New("task is unconfined")
+	// ErrTransitionDenied is returned when the current policy does not allow the transition,
+	// e.g. there is no matching change_profile rule.
+	ErrTransitionDenied = errors.New("transition denied by policy")
+	// ErrProfileNotFound is returned when the target profile is not loaded.
+	ErrProfileNotFound = errors.New("profile not found")
+	// ErrAppArmorDisabled is returned when AppArmor is not enabled in the kernel.
+	ErrAppArmorDisabled = errors.New("apparmor is not enabled")
+	// ErrAttrNotSet is returned when reading the prev or exec attr of a task
+	// that is not in a hat or has no pending exec transition.
+	ErrAttrNotSet = errors.New("proc attr not set")
+)
+
+var (
+	// ErrHatEnter matches a *HatError returned when a hat could not be entered.
+	ErrHatEnter = errors.New("cannot enter hat")
+	// ErrHatExit matches a *HatError returned when a hat could not be left.
+	ErrHatExit = errors.New("cannot leave hat")
+)
+
+// HatError records a failure to enter or leave a hat around a confined function.
+type HatError struct {
+	Hat string
+	// Exit is set if the hat was entered but could not be left
+	Exit bool
+	Err  error
+}
+
+func (e *HatError) Error() string {
+	if e.Exit {
+		return fmt.Sprintf("%v %q: %v", ErrHatExit, e.Hat, e.Err)
+	}
+	return fmt.Sprintf("%v %q: %v", ErrHatEnter, e.Hat, e.Err)
+}
+
+func (e *HatError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HatError) Is(target error) bool {
+	return (target == ErrHatEnter && !e.Exit) || (target == ErrHatExit && e.Exit)
+}
+
+// HatPanicError is returned when a function run in a hat panics.
+type HatPanicError struct {
+	Hat string
+	// Value is the value passed to panic()
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+	// ExitErr is the result of attempting to leave the hat after the panic.
+	// The thread is retired regardless.
+	ExitErr error
+}
+
+func (e *HatPanicError) Error() string {
+	if e.ExitErr != nil {
+		return fmt.Sprintf("panic in hat %q: %v (%v %q: %v)", e.Hat, e.Value, ErrHatExit, e.Hat, e.ExitErr)
+	}
+	return fmt.Sprintf("panic in hat %q: %v", e.Hat, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *HatPanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Is reports ErrHatExit if the hat could not be left after the panic.
+func (e *HatPanicError) Is(target error) bool {
+	return target == ErrHatExit && e.ExitErr != nil
+}
+
+// Repanic panics in the calling goroutine with the original panic value.
+// The original stack trace is available in e.Stack.
+func (e *HatPanicError) Repanic() {
+	panic(e.Value)
+}
+
+// ProfilePanicError is returned by WithProfile() and WithStack() when the function
+// run under the profile panics. The thread is always retired.
+type ProfilePanicError struct {
+	Profile string
+	// Value is the value passed to panic()
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *ProfilePanicError) Error() string {
+	return fmt.Sprintf("panic in profile %q: %v", e.Profile, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *ProfilePanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Repanic panics in the calling goroutine with the original panic value.
+// The original stack trace is available in e.Stack.
+func (e *ProfilePanicError) Repanic() {
+	panic(e.Value)
+}
+
+// Transition operations reported in TransitionError.Op
+const (
+	OpChangeHat     = "changehat"
+	OpChangeHatV    = "changehatv"
+	OpChangeProfile = "changeprofile"
+	OpChangeOnExec  = "changeonexec"
+	OpStackProfile  = "stackprofile"
+	OpStackOnExec   = "stackonexec"
+)
+
+// TransitionError records a failed write to a proc attr during a transition.
+//
+// errors.Is() matches the underlying syscall.Errno as well as one of the
+// sentinel errors above if the cause could be determined.
+type TransitionError struct {
+	// Op is one of the Op* constants
+	Op string
+	// Target is the hat or profile transitioned to, empty when leaving a hat
+	Target string
+	// Errno is the error returned by the kernel, 0 if the failure was not a syscall error
+	Errno syscall.Errno
+	// Label is the confinement label of the thread the transition failed on,
+	// read right after the failure, empty if unknown
+	Label string
+	// Err is the original error
+	Err error
+
+	cause error
+}
+
+func newTransitionError(op string, target string, tid int, err error) *TransitionError {
+	e := &TransitionError{Op: op, Target: target, Err: err}
+	errors.As(err, &e.Errno)
+	// read the label of the thread the write failed on, the caller may have moved since
+	if label, _, err := AAGetTaskCon(tid); err == nil {
+		e.Label = label
+	}
+	enabled, err := AAIsEnabled()
+	if err != nil {
+		// the module parameters only exist if apparmor is built into the kernel
+		enabled = !os.IsNotExist(err)
+	}
+	e.cause = classifyTransition(op, target, e.Errno, e.Label, enabled)
+	return e
+}
+
+// inSubprofile returns if any stack component of label is a hat or child profile.
+// Stacked labels like "a//&b" also contain the hierarchy separator, so the label is parsed.
+func inSubprofile(label string) bool {
+	l, err := ParseLabel(label)
+	if err != nil {
+		return false
+	}
+	for _, c := range l.Components {
+		if len(c.Path) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyTransition maps a failed transition to one of the sentinel errors,
+// following the error codes returned by security/apparmor/domain.c
+func classifyTransition(op string, target string, errno syscall.Errno, label string, enabled bool) error {
+	if !enabled {
+		return ErrAppArmorDisabled
+	}
+	denied := errno == syscall.EACCES || errno == syscall.EPERM
+	switch op {
+	case OpChangeHat, OpChangeHatV:
+		switch {
+		case denied && label == labelUnconfined:
+			return ErrUnconfined
+		case (errno == syscall.ENOENT || errno == syscall.ECHILD) && target != "":
+			return ErrHatNotFound
+		case denied && inSubprofile(label):
+			return ErrBadMagic
+		case denied:
+			return ErrTransitionDenied
+		}
+	case OpChangeProfile, OpChangeOnExec, OpStackProfile, OpStackOnExec:
+		switch {
+		case errno == syscall.ENOENT:
+			return ErrProfileNotFound
+		case denied:
+			return ErrTransitionDenied
+		}
+	}
+	return nil
+}
+
+func (e *TransitionError) Error() string {
+	var b strings.Builder
+	b.WriteString("apparmor ")
+	b.WriteString(e.Op)
+	if e.Target != "" {
+		fmt.Fprintf(&b, " %q", e.Target)
+	}
+	if e.Label != "" {
+		fmt.Fprintf(&b, " from %q", e.Label)
+	}
+	if e.cause != nil {
+		b.WriteString(": ")
+		b.WriteString(e.cause.Error())
+	}
+	b.WriteString(": ")
+	if e.Errno != 0 {
+		b.WriteString(e.Errno.Error())
+	} else {
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return e.cause != nil && target == e.cause
+}
@@ -0,0 +1,52 @@
+package apparmor
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyTransition(t *testing.T) {
+	testCases := []struct {
+		op     string
+		target string
+		errno  syscall.Errno
+		label  string
+		cause  error
+	}{
+		{OpChangeHat, "test-hat", syscall.EPERM, "unconfined", ErrUnconfined},
+		{OpChangeHat, "test-hat", syscall.ENOENT, "test-profile", ErrHatNotFound},
+		{OpChangeHatV, "a,b", syscall.ECHILD, "test-profile", ErrHatNotFound},
+		{OpChangeHat, "", syscall.EACCES, "test-profile//test-hat", ErrBadMagic},
+		{OpChangeHat, "other-hat", syscall.EACCES, "test-profile//test-hat", ErrBadMagic},
+		{OpChangeHat, "test-hat", syscall.EACCES, "test-profile", ErrTransitionDenied},
+		{OpChangeHat, "test-hat", syscall.EACCES, "test-profile//&other", ErrTransitionDenied},
+		{OpChangeHat, "", syscall.EACCES, "other//&test-profile//test-hat", ErrBadMagic},
+		{OpChangeProfile, "other", syscall.EACCES, "test-profile", ErrTransitionDenied},
+		{OpStackProfile, "other", syscall.EPERM, "test-profile", ErrTransitionDenied},
+		{OpChangeOnExec, "missing", syscall.ENOENT, "test-profile", ErrProfileNotFound},
+		{OpStackOnExec, "other", syscall.EINVAL, "test-profile", nil},
+	}
+	for _, c := range testCases {
+		assert.Equal(t, c.cause, classifyTransition(c.op, c.target, c.errno, c.label, true), c)
+	}
+	assert.Equal(t, ErrAppArmorDisabled, classifyTransition(OpChangeHat, "test-hat", syscall.EINVAL, "", false))
+}
+
+func TestTransitionErrorIs(t *testing.T) {
+	pathErr := &os.PathError{Op: "open", Path: "/proc/1/attr/apparmor/current", Err: syscall.ENOENT}
+	err := &TransitionError{
+		Op:     OpChangeHat,
+		Target: "test-hat",
+		Errno:  syscall.ENOENT,
+		Label:  "test-profile",
+		Err:    pathErr,
+		cause:  ErrHatNotFound,
+	}
+	assert.ErrorIs(t, err, ErrHatNotFound)
+	assert.ErrorIs(t, err, syscall.ENOENT)
+	assert.NotErrorIs(t, err, ErrBadMagic)
+	assert.Equal(t, `apparmor changehat "test-hat" from "test-profile": hat not found: no such file or directory`, err.Error())
+}
@@ -0,0 +1,279 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// libraries/libapparmor/src/features.c
+
+// FeatureNode is a single entry of the kernel features tree.
+type FeatureNode struct {
+	Name string
+	// Value is the raw content of a feature file, empty for directories
+	Value string
+	// Children are the entries of a feature directory, nil for files
+	Children []*FeatureNode
+}
+
+// IsDir returns if the node is a feature directory.
+func (n *FeatureNode) IsDir() bool {
+	return n.Children != nil
+}
+
+// Features is the set of features supported by a kernel,
+// as exposed in <securityfs>/apparmor/features.
+// functional replica of aa_features in libapparmor
+type Features struct {
+	nodes  []*FeatureNode
+	str    string
+	hashID string
+}
+
+var kernelFeatures struct {
+	mu       sync.Mutex
+	features *Features
+}
+
+// getKernelFeatures returns the features of the running kernel, loaded once per process.
+// Failures are not cached, so a transient error is retried on the next call.
+func getKernelFeatures() (*Features, error) {
+	kernelFeatures.mu.Lock()
+	defer kernelFeatures.mu.Unlock()
+	if kernelFeatures.features != nil {
+		return kernelFeatures.features, nil
+	}
+	features, err := NewFeaturesFromKernel()
+	if err != nil {
+		return nil, err
+	}
+	kernelFeatures.features = features
+	return features, nil
+}
+
+// NewFeaturesFromKernel loads the features supported by the running kernel.
+// functional replica of aa_features_new_from_kernel() in libapparmor
+func NewFeaturesFromKernel() (*Features, error) {
+	mnt, err := findMountPoint()
+	if err != nil {
+		return nil, err
+	}
+	return NewFeatures(path.Join(mnt, "features"))
+}
+
+// NewFeatures loads features from a features directory or from a file
+// containing a features string, such as the "features" file in the apparmor_parser cache.
+// functional replica of aa_features_new() in libapparmor
+func NewFeatures(filename string) (*Features, error) {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		r, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return NewFeaturesFromString(string(r))
+	}
+
+	nodes, err := loadFeatureDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newFeatures(nodes), nil
+}
+
+// NewFeaturesFromString parses a features string in the format used by apparmor_parser.
+// functional replica of aa_features_new_from_string() in libapparmor
+func NewFeaturesFromString(str string) (*Features, error) {
+	nodes, rest, err := parseFeatureNodes(str)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, errors.New("invalid features string: unbalanced '}'")
+	}
+	return &Features{nodes: nodes, str: str, hashID: featuresHash(str)}, nil
+}
+
+func newFeatures(nodes []*FeatureNode) *Features {
+	var b strings.Builder
+	writeFeatureNodes(&b, nodes)
+	str := b.String()
+	return &Features{nodes: nodes, str: str, hashID: featuresHash(str)}
+}
+
+// loadFeatureDir walks a features directory in lexical order, skipping dot files.
+func loadFeatureDir(dir string) ([]*FeatureNode, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*FeatureNode, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		node := &FeatureNode{Name: entry.Name()}
+		entryPath := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if node.Children, err = loadFeatureDir(entryPath); err != nil {
+				return nil, err
+			}
+		} else if entry.Type().IsRegular() {
+			r, err := os.ReadFile(entryPath)
+			if err != nil {
+				return nil, err
+			}
+			node.Value = string(r)
+		} else {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func writeFeatureNodes(b *strings.Builder, nodes []*FeatureNode) {
+	for _, node := range nodes {
+		b.WriteString(node.Name)
+		b.WriteString(" {")
+		if node.IsDir() {
+			writeFeatureNodes(b, node.Children)
+		} else {
+			b.WriteString(node.Value)
+		}
+		b.WriteString("}\n")
+	}
+}
+
+// parseFeatureNodes parses one level of a features string.
+// It returns the remaining input starting at the '}' closing the level, if any.
+func parseFeatureNodes(str string) ([]*FeatureNode, string, error) {
+	nodes := []*FeatureNode{}
+	for {
+		str = strings.TrimLeft(str, " \t\n")
+		if str == "" || str[0] == '}' {
+			return nodes, str, nil
+		}
+
+		open := strings.IndexByte(str, '{')
+		if open < 0 {
+			return nil, "", fmt.Errorf("invalid features string: expected '{' after %q", str)
+		}
+		node := &FeatureNode{Name: strings.TrimSpace(str[:open])}
+		if node.Name == "" || strings.ContainsAny(node.Name, "}\n") {
+			return nil, "", fmt.Errorf("invalid features string: bad feature name %q", str[:open])
+		}
+
+		depth := 0
+		end := -1
+		for i := open; i < len(str) && end < 0; i++ {
+			switch str[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return nil, "", fmt.Errorf("invalid features string: unterminated feature %q", node.Name)
+		}
+
+		content := str[open+1 : end]
+		if strings.ContainsRune(content, '{') {
+			children, rest, err := parseFeatureNodes(content)
+			if err != nil {
+				return nil, "", err
+			}
+			if strings.TrimSpace(rest) != "" {
+				return nil, "", fmt.Errorf("invalid features string: unexpected '}' in %q", node.Name)
+			}
+			node.Children = children
+		} else {
+			node.Value = content
+		}
+		nodes = append(nodes, node)
+		str = str[end+1:]
+	}
+}
+
+// featuresHash computes the djb2 hash apparmor_parser uses to name its cache directories.
+func featuresHash(str string) string {
+	hash := uint32(5381)
+	for i := 0; i < len(str); i++ {
+		// C char is signed on the platforms apparmor_parser runs on
+		hash = (hash << 5) + hash + uint32(int8(str[i]))
+	}
+	return fmt.Sprintf("%08x", hash)
+}
+
+// String returns the features string in the format used by apparmor_parser.
+func (f *Features) String() string {
+	return f.str
+}
+
+// ID returns the hash of the features string, which apparmor_parser uses as
+// the cache key for policy compiled against these features.
+// functional replica of aa_features_id() in libapparmor
+func (f *Features) ID() string {
+	return f.hashID
+}
+
+// Nodes returns the top level entries of the features tree.
+func (f *Features) Nodes() []*FeatureNode {
+	return f.nodes
+}
+
+// IsEqual returns if two sets of features are identical.
+// functional replica of aa_features_is_equal() in libapparmor
+func (f *Features) IsEqual(o *Features) bool {
+	return f != nil && o != nil && f.str == o.str
+}
+
+// Lookup returns the node at the slash separated path, e.g. "domain/stack", or nil
+// if the feature is not present.
+func (f *Features) Lookup(featurePath string) *FeatureNode {
+	nodes := f.nodes
+	var found *FeatureNode
+	for _, name := range strings.Split(strings.Trim(featurePath, "/"), "/") {
+		found = nil
+		for _, node := range nodes {
+			if node.Name == name {
+				found = node
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		nodes = found.Children
+	}
+	return found
+}
+
+// Supports returns if the feature at the slash separated path,
+// e.g. "domain/stack", is present.
+// functional replica of aa_features_supports() in libapparmor
+func (f *Features) Supports(featurePath string) bool {
+	return f.Lookup(featurePath) != nil
+}
+
+// Value returns the content of the feature file at the slash separated path,
+// without the trailing newline.
+func (f *Features) Value(featurePath string) (string, bool) {
+	node := f.Lookup(featurePath)
+	if node == nil || node.IsDir() {
+		return "", false
+	}
+	return strings.TrimSuffix(node.Value, "\n"), true
+}
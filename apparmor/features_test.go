@@ -0,0 +1,88 @@
+package apparmor
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFeaturesString = "domain {change_hat {yes\n}\nchange_hatv {yes\n}\nstack {yes\n}\nversion {1.2\n}\n}\n" +
+	"network {af_unix {yes\n}\n}\n" +
+	"policy {versions {v7 {yes\n}\n}\n}\n"
+
+func TestFeaturesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"domain/change_hat":   "yes\n",
+		"domain/change_hatv":  "yes\n",
+		"domain/stack":        "yes\n",
+		"domain/version":      "1.2\n",
+		"network/af_unix":     "yes\n",
+		"policy/versions/v7":  "yes\n",
+		"policy/versions/.ns": "ignored\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(content), 0644))
+	}
+
+	features, err := NewFeatures(dir)
+	require.NoError(t, err)
+	assert.Equal(t, testFeaturesString, features.String())
+
+	fromString, err := NewFeaturesFromString(features.String())
+	require.NoError(t, err)
+	assert.True(t, features.IsEqual(fromString))
+	assert.Equal(t, features.ID(), fromString.ID())
+	assert.Equal(t, features.Nodes(), fromString.Nodes())
+
+	cacheFile := path.Join(t.TempDir(), "features")
+	require.NoError(t, os.WriteFile(cacheFile, []byte(features.String()), 0644))
+	fromFile, err := NewFeatures(cacheFile)
+	require.NoError(t, err)
+	assert.True(t, features.IsEqual(fromFile))
+}
+
+func TestFeaturesSupports(t *testing.T) {
+	features, err := NewFeaturesFromString(testFeaturesString)
+	require.NoError(t, err)
+
+	assert.True(t, features.Supports("domain"))
+	assert.True(t, features.Supports("domain/stack"))
+	assert.True(t, features.Supports("/policy/versions/v7"))
+	assert.False(t, features.Supports("policy/versions/v8"))
+	assert.False(t, features.Supports("dbus"))
+	assert.False(t, features.Supports(""))
+
+	value, ok := features.Value("domain/version")
+	assert.True(t, ok)
+	assert.Equal(t, "1.2", value)
+	_, ok = features.Value("domain")
+	assert.False(t, ok)
+}
+
+func TestFeaturesStringInvalid(t *testing.T) {
+	for _, str := range []string{"domain {", "domain {yes}}", "{yes}", "domain"} {
+		_, err := NewFeaturesFromString(str)
+		assert.Error(t, err, str)
+	}
+}
+
+func TestFeaturesHash(t *testing.T) {
+	assert.Equal(t, "00001505", featuresHash(""))
+	// 5381*33 + 'a'
+	assert.Equal(t, "0002b606", featuresHash("a"))
+	assert.Len(t, featuresHash(testFeaturesString), 8)
+}
+
+func TestGetKernelFeaturesErrorNotCached(t *testing.T) {
+	if _, err := getKernelFeatures(); err == nil {
+		t.Skip("kernel features are readable")
+	}
+	kernelFeatures.mu.Lock()
+	defer kernelFeatures.mu.Unlock()
+	assert.Nil(t, kernelFeatures.features)
+}
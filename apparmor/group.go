@@ -0,0 +1,108 @@
+//go:build linux
+package apparmor
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// HatGroup runs a group of functions in a hat, each on its own OS thread,
+// in the manner of golang.org/x/sync/errgroup.
+//
+// Every function passed to Go() only starts after its thread has entered
+// the hat and the transition was verified, it never runs on an unconfined thread.
+// The first function to return an error, or to fail to enter or leave the hat,
+// cancels the context returned by NewHatGroup(). Functions that have not
+// started by then are skipped.
+type HatGroup struct {
+	hat   string
+	magic func() uint64
+	enter func() error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// NewHatGroup returns a new HatGroup running functions in hat and a context derived from ctx
+// that is cancelled when a function fails or Wait() returns.
+//
+// At most limit functions run concurrently, Go() blocks until a slot is available.
+// A limit <= 0 does not limit the number of threads, see the performance note on WithHat().
+//
+// Magic is a function that when called should return the same non-zero value as the
+// magic token for transitioning out of the hat, see WithHat().
+func NewHatGroup(ctx context.Context, hat string, magic func() uint64, limit int) (*HatGroup, context.Context, error) {
+	if hat == "" {
+		return nil, nil, errors.New("NewHatGroup() does not accept empty string")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	g := &HatGroup{
+		hat:    hat,
+		magic:  magic,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	g.enter = func() error {
+		return SetHat(g.hat, g.magic())
+	}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g, ctx, nil
+}
+
+// Go runs fn in the hat on a new goroutine locked to its own thread.
+//
+// Failures to enter or leave the hat are reported as *HatError, and a panic
+// in fn as *HatPanicError, see WithHat().
+func (g *HatGroup) Go(fn func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+
+		if err := g.ctx.Err(); err != nil {
+			g.setErr(err)
+			return
+		}
+
+		var fnErr error
+		err := runInHat(g.hat, g.enter, g.magic, func() {
+			fnErr = fn()
+		})
+		if err == nil {
+			err = fnErr
+		}
+		if err != nil {
+			g.setErr(err)
+		}
+	}()
+}
+
+// Wait blocks until all functions started by Go() have returned and their
+// threads left the hat, then returns the first error.
+func (g *HatGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+func (g *HatGroup) setErr(err error) {
+	g.errOnce.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
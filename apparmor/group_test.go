@@ -0,0 +1,53 @@
+package apparmor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHatGroupInvalid(t *testing.T) {
+	_, _, err := NewHatGroup(context.Background(), "", testMagic, 1)
+	assert.Error(t, err)
+}
+
+func TestHatGroupEnterFailure(t *testing.T) {
+	g, ctx, err := NewHatGroup(context.Background(), "go-apparmor-nonexistent-hat", testMagic, 1)
+	require.NoError(t, err)
+
+	called := false
+	g.Go(func() error {
+		called = true
+		return nil
+	})
+	err = g.Wait()
+	assert.ErrorIs(t, err, ErrHatEnter)
+	assert.False(t, called)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	// functions started after the group is cancelled are skipped
+	g.Go(func() error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, g.Wait(), ErrHatEnter)
+	assert.False(t, called)
+}
+
+func TestHatGroupCancelled(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	g, _, err := NewHatGroup(parent, "test-hat", testMagic, 0)
+	require.NoError(t, err)
+
+	called := false
+	g.Go(func() error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, g.Wait(), context.Canceled)
+	assert.False(t, called)
+}
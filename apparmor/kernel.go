@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -40,6 +41,139 @@ func findMountPoint() (string, error) {
 	return "", errors.New("apparmor not loaded or not using securityfs")
 }
 
+// ProfileEntry is a loaded profile as listed in <securityfs>/apparmor/profiles
+type ProfileEntry struct {
+	Name string
+	Mode Mode
+}
+
+// ListProfiles returns the profiles currently loaded in the kernel
+// along with their modes.
+func ListProfiles() ([]ProfileEntry, error) {
+	mnt, err := findMountPoint()
+	if err != nil {
+		return nil, err
+	}
+	return listProfiles(mnt)
+}
+
+func listProfiles(mnt string) ([]ProfileEntry, error) {
+	f, err := os.Open(path.Join(mnt, "profiles"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var profiles []ProfileEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		name, mode, err := SplitConMode(line)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse profile entry %q: %v", line, err)
+		}
+		profiles = append(profiles, ProfileEntry{Name: name, Mode: mode})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// IsProfileLoaded return if a profile named name is loaded in the kernel
+func IsProfileLoaded(name string) (bool, error) {
+	profiles, err := ListProfiles()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ProfileInfo is a loaded profile as exposed in <securityfs>/apparmor/policy/profiles/*
+//
+// Fields not exposed by the running kernel are left empty.
+type ProfileInfo struct {
+	// Dir is the directory the information was read from
+	Dir    string
+	Name   string
+	Mode   Mode
+	Attach string
+	// Hash is read from "hash", or "sha1" on older kernels
+	Hash     string
+	Revision uint64
+}
+
+// ListPolicyProfiles returns detailed information on the profiles loaded in
+// the root policy namespace.
+func ListPolicyProfiles() ([]ProfileInfo, error) {
+	mnt, err := findMountPoint()
+	if err != nil {
+		return nil, err
+	}
+	return listPolicyProfiles(mnt)
+}
+
+func listPolicyProfiles(mnt string) ([]ProfileInfo, error) {
+	profilesDir := path.Join(mnt, "policy", "profiles")
+	entries, err := os.ReadDir(profilesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	readAttr := func(dir string, names ...string) (string, error) {
+		for _, name := range names {
+			r, err := os.ReadFile(path.Join(dir, name))
+			if err == nil {
+				return strings.TrimSuffix(string(r), "\n"), nil
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+
+	var profiles []ProfileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info := ProfileInfo{Dir: path.Join(profilesDir, entry.Name())}
+		if info.Name, err = readAttr(info.Dir, "name"); err != nil {
+			return nil, err
+		}
+		mode, err := readAttr(info.Dir, "mode")
+		if err != nil {
+			return nil, err
+		}
+		info.Mode = ParseMode(mode)
+		if info.Attach, err = readAttr(info.Dir, "attach"); err != nil {
+			return nil, err
+		}
+		if info.Hash, err = readAttr(info.Dir, "hash", "sha1"); err != nil {
+			return nil, err
+		}
+		revision, err := readAttr(info.Dir, "revision")
+		if err != nil {
+			return nil, err
+		}
+		if revision != "" {
+			if info.Revision, err = strconv.ParseUint(revision, 10, 64); err != nil {
+				return nil, fmt.Errorf("cannot parse revision of %q: %v", info.Name, err)
+			}
+		}
+		profiles = append(profiles, info)
+	}
+	return profiles, nil
+}
+
 type ParamBase string
 
 const (
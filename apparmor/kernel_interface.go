@@ -0,0 +1,150 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// libraries/libapparmor/src/kernel_interface.c
+
+var (
+	// ErrPolicyExists is returned when loading a policy that is already loaded (EEXIST).
+	ErrPolicyExists = errors.New("policy already exists")
+	// ErrPolicyNotFound is returned when removing or replacing a policy that is not loaded (ENOENT).
+	ErrPolicyNotFound = errors.New("policy not found")
+	// ErrPolicyPermission is returned when the caller is not allowed to manage policy (EPERM/EACCES).
+	ErrPolicyPermission = errors.New("permission denied to manage policy")
+)
+
+// PolicyError records a failed policy management operation.
+//
+// errors.Is() matches both the underlying syscall.Errno and the corresponding
+// ErrPolicy* sentinel.
+type PolicyError struct {
+	// Op is the interface file written to, one of ".load", ".replace" or ".remove"
+	Op string
+	// Name is the profile name for ".remove", empty otherwise
+	Name string
+	Err  error
+}
+
+func (e *PolicyError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("apparmor %s %q: %v", e.Op, e.Name, e.Err)
+	}
+	return fmt.Sprintf("apparmor %s: %v", e.Op, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PolicyError) Is(target error) bool {
+	switch target {
+	case ErrPolicyExists:
+		return errors.Is(e.Err, syscall.EEXIST)
+	case ErrPolicyNotFound:
+		return errors.Is(e.Err, syscall.ENOENT)
+	case ErrPolicyPermission:
+		return errors.Is(e.Err, syscall.EPERM) || errors.Is(e.Err, syscall.EACCES)
+	}
+	return false
+}
+
+// KernelInterface loads, replaces and removes precompiled binary policy
+// through the apparmor securityfs interface.
+// functional replica of aa_kernel_interface in libapparmor
+type KernelInterface struct {
+	dir string
+}
+
+// NewKernelInterface returns a KernelInterface operating on the apparmor
+// securityfs directory apparmorfs. If apparmorfs is empty, the mount point is
+// looked up from /proc/mounts.
+// functional replica of aa_kernel_interface_new() in libapparmor
+func NewKernelInterface(apparmorfs string) (*KernelInterface, error) {
+	if apparmorfs == "" {
+		mnt, err := findMountPoint()
+		if err != nil {
+			return nil, err
+		}
+		apparmorfs = mnt
+	}
+	return &KernelInterface{dir: apparmorfs}, nil
+}
+
+// writePolicy writes buf to the interface file op in a single write() call.
+// The kernel requires the whole policy blob to arrive at once.
+//
+// Only errors of the write are returned as *PolicyError, a failure to open the
+// interface file is returned as is and does not match the ErrPolicy* sentinels.
+func (k *KernelInterface) writePolicy(op string, name string, buf []byte) error {
+	f, err := os.OpenFile(path.Join(k.dir, op), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := f.Write(buf)
+	if err != nil {
+		return &PolicyError{Op: op, Name: name, Err: unwrapPathError(err)}
+	}
+	if n != len(buf) {
+		return &PolicyError{Op: op, Name: name, Err: syscall.EPROTO}
+	}
+	return nil
+}
+
+// LoadPolicy loads the binary policy in buf into the kernel.
+// functional replica of aa_kernel_interface_load_policy() in libapparmor
+func (k *KernelInterface) LoadPolicy(buf []byte) error {
+	return k.writePolicy(".load", "", buf)
+}
+
+// ReplacePolicy loads the binary policy in buf into the kernel,
+// replacing any profile of the same name.
+// functional replica of aa_kernel_interface_replace_policy() in libapparmor
+func (k *KernelInterface) ReplacePolicy(buf []byte) error {
+	return k.writePolicy(".replace", "", buf)
+}
+
+// RemovePolicy removes the profile with the fully qualified name fqname from the kernel.
+// functional replica of aa_kernel_interface_remove_policy() in libapparmor
+func (k *KernelInterface) RemovePolicy(fqname string) error {
+	// the kernel expects the name including the trailing NUL
+	return k.writePolicy(".remove", fqname, append([]byte(fqname), 0))
+}
+
+// LoadPolicyFromFile loads the binary policy stored in the file at path into the kernel.
+// functional replica of aa_kernel_interface_load_policy_from_file() in libapparmor
+func (k *KernelInterface) LoadPolicyFromFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return k.LoadPolicy(buf)
+}
+
+// ReplacePolicyFromFile replaces policy with the binary policy stored in the file at path.
+// functional replica of aa_kernel_interface_replace_policy_from_file() in libapparmor
+func (k *KernelInterface) ReplacePolicyFromFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return k.ReplacePolicy(buf)
+}
+
+// unwrapPathError strips the *os.PathError wrapper so the errno is reported
+// against the operation instead of the interface file path.
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
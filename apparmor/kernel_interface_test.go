@@ -0,0 +1,64 @@
+package apparmor
+
+import (
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKernelInterfaceWrite(t *testing.T) {
+	dir := t.TempDir()
+	for _, op := range []string{".load", ".replace", ".remove"} {
+		require.NoError(t, os.WriteFile(path.Join(dir, op), nil, 0600))
+	}
+	kif, err := NewKernelInterface(dir)
+	require.NoError(t, err)
+
+	blob := []byte("\x04\x08\x00version\x00\x02\x00\x00\x00")
+	assert.NoError(t, kif.LoadPolicy(blob))
+	assert.NoError(t, kif.ReplacePolicy(blob))
+	assert.NoError(t, kif.RemovePolicy("test-profile"))
+
+	written, err := os.ReadFile(path.Join(dir, ".load"))
+	require.NoError(t, err)
+	assert.Equal(t, blob, written)
+	written, err = os.ReadFile(path.Join(dir, ".remove"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test-profile\x00"), written)
+}
+
+func TestPolicyErrorIs(t *testing.T) {
+	// an interface file that cannot be opened is not a missing policy
+	kif, err := NewKernelInterface(t.TempDir())
+	require.NoError(t, err)
+	err = kif.RemovePolicy("test-profile")
+	assert.ErrorIs(t, err, syscall.ENOENT)
+	assert.NotErrorIs(t, err, ErrPolicyNotFound)
+	var policyErr *PolicyError
+	assert.False(t, errors.As(err, &policyErr))
+
+	err = &PolicyError{Op: ".remove", Name: "test-profile", Err: syscall.ENOENT}
+	assert.ErrorIs(t, err, ErrPolicyNotFound)
+	assert.ErrorIs(t, err, syscall.ENOENT)
+	assert.NotErrorIs(t, err, ErrPolicyExists)
+
+	testCases := []struct {
+		errno    syscall.Errno
+		sentinel error
+	}{
+		{syscall.EEXIST, ErrPolicyExists},
+		{syscall.ENOENT, ErrPolicyNotFound},
+		{syscall.EPERM, ErrPolicyPermission},
+		{syscall.EACCES, ErrPolicyPermission},
+	}
+	for _, c := range testCases {
+		err := &PolicyError{Op: ".load", Err: c.errno}
+		assert.ErrorIs(t, err, c.sentinel)
+		assert.ErrorIs(t, err, c.errno)
+	}
+}
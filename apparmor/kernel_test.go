@@ -2,6 +2,7 @@ package apparmor
 
 import (
 	"os"
+	"path"
 	"strings"
 	"testing"
 
@@ -55,3 +56,60 @@ func TestGetProcAttr(t *testing.T) {
 	assert.Equal(t, "unconfined", label)
 	assert.Equal(t, "", mode)
 }
+
+func TestListProfilesStatic(t *testing.T) {
+	mnt := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(mnt, "profiles"), []byte(
+		"test-profile (complain)\n"+
+			"test-profile//test-hat (enforce)\n"+
+			"/usr/bin/program with space (kill)\n"), 0644))
+
+	profiles, err := listProfiles(mnt)
+	require.NoError(t, err)
+	assert.Equal(t, []ProfileEntry{
+		{Name: "test-profile", Mode: "complain"},
+		{Name: "test-profile//test-hat", Mode: "enforce"},
+		{Name: "/usr/bin/program with space", Mode: "kill"},
+	}, profiles)
+}
+
+func TestListPolicyProfilesStatic(t *testing.T) {
+	mnt := t.TempDir()
+	writeAttrs := func(dir string, attrs map[string]string) {
+		dir = path.Join(mnt, "policy", "profiles", dir)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		for name, value := range attrs {
+			require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(value), 0644))
+		}
+	}
+	writeAttrs("test-profile.1", map[string]string{
+		"name":     "test-profile\n",
+		"mode":     "complain\n",
+		"attach":   "/usr/bin/test\n",
+		"hash":     "0123abcd\n",
+		"revision": "3\n",
+	})
+	writeAttrs("legacy.2", map[string]string{
+		"name": "legacy\n",
+		"mode": "enforce\n",
+		"sha1": "deadbeef\n",
+	})
+
+	profiles, err := listPolicyProfiles(mnt)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	assert.Equal(t, ProfileInfo{
+		Dir:  path.Join(mnt, "policy", "profiles", "legacy.2"),
+		Name: "legacy",
+		Mode: "enforce",
+		Hash: "deadbeef",
+	}, profiles[0])
+	assert.Equal(t, ProfileInfo{
+		Dir:      path.Join(mnt, "policy", "profiles", "test-profile.1"),
+		Name:     "test-profile",
+		Mode:     "complain",
+		Attach:   "/usr/bin/test",
+		Hash:     "0123abcd",
+		Revision: 3,
+	}, profiles[1])
+}
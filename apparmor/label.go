@@ -0,0 +1,205 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	labelStackSeparator     = "//&"
+	labelHierarchySeparator = "//"
+	labelNullPrefix         = "null-"
+	labelUnconfined         = "unconfined"
+)
+
+// LabelComponent is a single profile of a (possibly stacked) label,
+// for example ":ns:profile//hat".
+type LabelComponent struct {
+	// Namespace is the policy namespace of the profile, empty for the current namespace
+	Namespace string
+	// Path is the profile hierarchy, starting with the top level profile
+	// followed by hats or child profiles.
+	Path []string
+}
+
+// Name returns the hierarchical name of the component without the namespace,
+// for example "profile//hat".
+func (c LabelComponent) Name() string {
+	return strings.Join(c.Path, labelHierarchySeparator)
+}
+
+// Profile returns the top level profile of the component.
+func (c LabelComponent) Profile() string {
+	if len(c.Path) == 0 {
+		return ""
+	}
+	return c.Path[0]
+}
+
+// Hat returns the innermost hat or child profile of the component,
+// or an empty string if the component is a top level profile.
+func (c LabelComponent) Hat() string {
+	if len(c.Path) < 2 {
+		return ""
+	}
+	return c.Path[len(c.Path)-1]
+}
+
+// IsNull returns if the component is a null- profile generated by the kernel,
+// usually as the result of a transition to an unknown hat or profile in complain mode.
+func (c LabelComponent) IsNull() bool {
+	return len(c.Path) > 0 && strings.HasPrefix(c.Path[len(c.Path)-1], labelNullPrefix)
+}
+
+// Equal returns if two components name the same profile.
+func (c LabelComponent) Equal(o LabelComponent) bool {
+	if c.Namespace != o.Namespace || len(c.Path) != len(o.Path) {
+		return false
+	}
+	for i := range c.Path {
+		if c.Path[i] != o.Path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c LabelComponent) String() string {
+	if c.Namespace != "" {
+		return ":" + c.Namespace + ":" + c.Name()
+	}
+	return c.Name()
+}
+
+// Label is a parsed AppArmor label, as returned by the kernel in the label part of
+// a confinement string.
+//
+// A label consists of one or more stacked components separated by "//&".
+type Label struct {
+	Components []LabelComponent
+}
+
+// ParseLabel parses the label part of a confinement string.
+// The mode should be removed beforehand, for example with SplitCon().
+func ParseLabel(label string) (Label, error) {
+	if label == "" {
+		return Label{}, errors.New("label is empty")
+	}
+	var ret Label
+	for _, part := range strings.Split(label, labelStackSeparator) {
+		comp, err := parseLabelComponent(part)
+		if err != nil {
+			return Label{}, fmt.Errorf("invalid label %q: %v", label, err)
+		}
+		ret.Components = append(ret.Components, comp)
+	}
+	return ret, nil
+}
+
+func parseLabelComponent(part string) (LabelComponent, error) {
+	var comp LabelComponent
+	if strings.HasPrefix(part, ":") {
+		end := strings.Index(part[1:], ":")
+		if end < 0 {
+			return comp, fmt.Errorf("unterminated namespace in %q", part)
+		}
+		comp.Namespace = part[1 : end+1]
+		if comp.Namespace == "" {
+			return comp, fmt.Errorf("empty namespace in %q", part)
+		}
+		// both ":ns:profile" and ":ns://profile" are valid
+		part = strings.TrimPrefix(part[end+2:], labelHierarchySeparator)
+	}
+	if part == "" {
+		return comp, errors.New("empty profile name")
+	}
+	comp.Path = strings.Split(part, labelHierarchySeparator)
+	for _, p := range comp.Path {
+		if p == "" {
+			return comp, fmt.Errorf("empty profile name in %q", part)
+		}
+	}
+	return comp, nil
+}
+
+func (l Label) String() string {
+	parts := make([]string, len(l.Components))
+	for i, c := range l.Components {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, labelStackSeparator)
+}
+
+// Equal returns if two labels consist of the same components in the same order.
+func (l Label) Equal(o Label) bool {
+	if len(l.Components) != len(o.Components) {
+		return false
+	}
+	for i := range l.Components {
+		if !l.Components[i].Equal(o.Components[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains returns if every component of o is also a component of l, in any order.
+func (l Label) Contains(o Label) bool {
+	for _, oc := range o.Components {
+		found := false
+		for _, c := range l.Components {
+			if c.Equal(oc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// IsUnconfined returns if the label is the special unconfined label.
+func (l Label) IsUnconfined() bool {
+	return len(l.Components) == 1 && l.Components[0].Namespace == "" &&
+		len(l.Components[0].Path) == 1 && l.Components[0].Path[0] == labelUnconfined
+}
+
+// IsStacked returns if the label consists of more than one component.
+func (l Label) IsStacked() bool {
+	return len(l.Components) > 1
+}
+
+// InHat returns if any component of the label is inside the hat named hat.
+func (l Label) InHat(hat string) bool {
+	if hat == "" {
+		return false
+	}
+	for _, c := range l.Components {
+		if c.Hat() == hat {
+			return true
+		}
+	}
+	return false
+}
+
+// Component returns the n-th stack component of the label.
+func (l Label) Component(n int) (LabelComponent, bool) {
+	if n < 0 || n >= len(l.Components) {
+		return LabelComponent{}, false
+	}
+	return l.Components[n], true
+}
+
+// Profile returns the top level profile of the n-th stack component,
+// or an empty string if there is no such component.
+func (l Label) Profile(n int) string {
+	c, ok := l.Component(n)
+	if !ok {
+		return ""
+	}
+	return c.Profile()
+}
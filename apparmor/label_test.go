@@ -0,0 +1,73 @@
+package apparmor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabelStatic(t *testing.T) {
+	testCases := []struct {
+		raw        string
+		components []LabelComponent
+		str        string
+	}{
+		{"unconfined", []LabelComponent{{Path: []string{"unconfined"}}}, "unconfined"},
+		{"/path/to/executable", []LabelComponent{{Path: []string{"/path/to/executable"}}}, "/path/to/executable"},
+		{"test-profile//test-hat", []LabelComponent{{Path: []string{"test-profile", "test-hat"}}}, "test-profile//test-hat"},
+		{"test-profile//null-test-subprofile", []LabelComponent{{Path: []string{"test-profile", "null-test-subprofile"}}}, "test-profile//null-test-subprofile"},
+		{":ns:profile", []LabelComponent{{Namespace: "ns", Path: []string{"profile"}}}, ":ns:profile"},
+		{":ns://profile//hat", []LabelComponent{{Namespace: "ns", Path: []string{"profile", "hat"}}}, ":ns:profile//hat"},
+		{"a//&b//hat", []LabelComponent{{Path: []string{"a"}}, {Path: []string{"b", "hat"}}}, "a//&b//hat"},
+		{"a//&:ns:b", []LabelComponent{{Path: []string{"a"}}, {Namespace: "ns", Path: []string{"b"}}}, "a//&:ns:b"},
+	}
+	for _, c := range testCases {
+		label, err := ParseLabel(c.raw)
+		require.NoError(t, err, c.raw)
+		assert.Equal(t, c.components, label.Components, c.raw)
+		assert.Equal(t, c.str, label.String(), c.raw)
+	}
+
+	for _, raw := range []string{"", ":ns", "::profile", ":ns:", "a//&", "a////b"} {
+		_, err := ParseLabel(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestLabelQueries(t *testing.T) {
+	assert.True(t, mustParseLabel("unconfined").IsUnconfined())
+	assert.False(t, mustParseLabel(":ns:unconfined").IsUnconfined())
+	assert.False(t, mustParseLabel("test-profile").IsUnconfined())
+
+	hatted := mustParseLabel("test-profile//test-hat")
+	assert.True(t, hatted.InHat("test-hat"))
+	assert.False(t, hatted.InHat("test-profile"))
+	assert.False(t, hatted.InHat(""))
+	assert.False(t, mustParseLabel("test-profile").InHat("test-profile"))
+
+	null := mustParseLabel("test-profile//null-test-subprofile")
+	assert.True(t, null.Components[0].IsNull())
+	assert.False(t, hatted.Components[0].IsNull())
+
+	stacked := mustParseLabel("a//&:ns:b//hat")
+	assert.True(t, stacked.IsStacked())
+	assert.False(t, hatted.IsStacked())
+	assert.Equal(t, "a", stacked.Profile(0))
+	assert.Equal(t, "b", stacked.Profile(1))
+	assert.Equal(t, "", stacked.Profile(2))
+	comp, ok := stacked.Component(1)
+	assert.True(t, ok)
+	assert.Equal(t, "ns", comp.Namespace)
+	assert.Equal(t, "b//hat", comp.Name())
+	assert.True(t, stacked.InHat("hat"))
+
+	assert.True(t, stacked.Equal(mustParseLabel("a//&:ns://b//hat")))
+	assert.False(t, stacked.Equal(mustParseLabel("a//&b//hat")))
+	assert.False(t, stacked.Equal(mustParseLabel("a")))
+
+	assert.True(t, stacked.Contains(mustParseLabel("a")))
+	assert.True(t, stacked.Contains(mustParseLabel(":ns:b//hat//&a")))
+	assert.False(t, stacked.Contains(mustParseLabel("b//hat")))
+	assert.False(t, mustParseLabel("a").Contains(stacked))
+}
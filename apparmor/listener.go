@@ -0,0 +1,178 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/eternal-flame-AD/go-apparmor/internal/glob"
+)
+
+// ErrPeerDenied is returned by a PeerAuthorizer when a peer is not allowed.
+var ErrPeerDenied = errors.New("peer denied by policy")
+
+// PeerAuthorizer decides whether a peer with the given label and mode is allowed.
+//
+// AuthorizePeer returns nil if the peer is allowed, and an error explaining
+// the decision otherwise, which should match ErrPeerDenied.
+type PeerAuthorizer interface {
+	AuthorizePeer(label Label, mode Mode) error
+}
+
+// PeerAuthorizerFunc is an adapter to use a function as a PeerAuthorizer.
+type PeerAuthorizerFunc func(label Label, mode Mode) error
+
+func (f PeerAuthorizerFunc) AuthorizePeer(label Label, mode Mode) error {
+	return f(label, mode)
+}
+
+// PeerRule matches a peer by globs over its label, profiles and mode,
+// see [glob] for the syntax. An empty field matches anything.
+type PeerRule struct {
+	// Label is matched against the full label, e.g. "test-profile//*" matches any hat of test-profile
+	Label string
+	// Profile is matched against the top level profile of each stack component,
+	// the rule matches if any component matches. e.g. "test-profile" matches
+	// test-profile and all of its hats and children, unlike the label glob
+	// "test-profile**" which also matches "test-profile-untrusted".
+	Profile string
+	// Mode is matched against the mode, e.g. "enforce"
+	Mode string
+}
+
+// Validate checks the globs of the rule for syntax errors.
+func (r PeerRule) Validate() error {
+	for _, pattern := range []string{r.Label, r.Profile, r.Mode} {
+		if err := glob.Validate(pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns if the peer with label and mode matches the rule.
+func (r PeerRule) Matches(label Label, mode Mode) bool {
+	if r.Label != "" && !glob.Match(r.Label, label.String()) {
+		return false
+	}
+	if r.Mode != "" && !glob.Match(r.Mode, mode.String()) {
+		return false
+	}
+	if r.Profile != "" {
+		for _, c := range label.Components {
+			if glob.Match(r.Profile, c.Profile()) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// PeerPolicy is a PeerAuthorizer allowing peers that match any rule in Allow
+// and no rule in Deny. A policy without Allow rules denies every peer.
+type PeerPolicy struct {
+	Allow []PeerRule
+	Deny  []PeerRule
+}
+
+// Validate checks all rules of the policy for syntax errors.
+func (p *PeerPolicy) Validate() error {
+	for _, rules := range [][]PeerRule{p.Allow, p.Deny} {
+		for _, r := range rules {
+			if err := r.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *PeerPolicy) AuthorizePeer(label Label, mode Mode) error {
+	for i, r := range p.Deny {
+		if r.Matches(label, mode) {
+			return fmt.Errorf("%w: %q matches deny rule %d", ErrPeerDenied, FormatCon(label.String(), mode), i)
+		}
+	}
+	for _, r := range p.Allow {
+		if r.Matches(label, mode) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q matches no allow rule", ErrPeerDenied, FormatCon(label.String(), mode))
+}
+
+// LabelConn is a connection accepted by a LabelListener,
+// along with the label and mode of its peer at the time of accept.
+type LabelConn struct {
+	*net.UnixConn
+	Label Label
+	Mode  Mode
+}
+
+// LabelListener wraps a *net.UnixListener and only accepts connections from
+// peers allowed by a PeerAuthorizer.
+//
+// Connections whose peer label cannot be determined, for example because the kernel
+// does not support AppArmor socket mediation, are rejected as well.
+type LabelListener struct {
+	listener   *net.UnixListener
+	authorizer PeerAuthorizer
+
+	// OnReject is called, if set, for every rejected connection before it is closed.
+	// err is the error returned by the authorizer or by the peer label lookup.
+	OnReject func(conn *net.UnixConn, label Label, mode Mode, err error)
+
+	peerCon func(conn *net.UnixConn) (Label, Mode, error)
+}
+
+// NewLabelListener returns a LabelListener accepting connections from l
+// whose peers are allowed by authorizer.
+func NewLabelListener(l *net.UnixListener, authorizer PeerAuthorizer) *LabelListener {
+	return &LabelListener{listener: l, authorizer: authorizer, peerCon: UnixPeerCon}
+}
+
+// Accept waits for and returns the next allowed connection as a *LabelConn,
+// implementing net.Listener.
+func (l *LabelListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptLabel()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// AcceptLabel waits for and returns the next allowed connection.
+// Rejected connections are closed and do not cause AcceptLabel to return.
+func (l *LabelListener) AcceptLabel() (*LabelConn, error) {
+	for {
+		conn, err := l.listener.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+
+		label, mode, err := l.peerCon(conn)
+		if err == nil {
+			err = l.authorizer.AuthorizePeer(label, mode)
+		}
+		if err == nil {
+			return &LabelConn{UnixConn: conn, Label: label, Mode: mode}, nil
+		}
+
+		if l.OnReject != nil {
+			l.OnReject(conn, label, mode, err)
+		}
+		conn.Close()
+	}
+}
+
+// Close closes the underlying listener.
+func (l *LabelListener) Close() error {
+	return l.listener.Close()
+}
+
+// Addr returns the address of the underlying listener.
+func (l *LabelListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
@@ -0,0 +1,83 @@
+package apparmor
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerPolicy(t *testing.T) {
+	policy := &PeerPolicy{
+		Allow: []PeerRule{
+			{Profile: "test-profile", Mode: "enforce"},
+			{Profile: "/usr/bin/*"},
+		},
+		Deny: []PeerRule{
+			{Label: "test-profile//untrusted"},
+		},
+	}
+	require.NoError(t, policy.Validate())
+
+	assert.NoError(t, policy.AuthorizePeer(mustParseLabel("test-profile"), ModeEnforce))
+	assert.NoError(t, policy.AuthorizePeer(mustParseLabel("test-profile//test-hat"), ModeEnforce))
+	assert.NoError(t, policy.AuthorizePeer(mustParseLabel("other//&/usr/bin/cat"), ModeComplain))
+
+	err := policy.AuthorizePeer(mustParseLabel("test-profile"), ModeComplain)
+	assert.ErrorIs(t, err, ErrPeerDenied)
+	assert.EqualError(t, err, `peer denied by policy: "test-profile (complain)" matches no allow rule`)
+	err = policy.AuthorizePeer(mustParseLabel("test-profile//untrusted"), ModeEnforce)
+	assert.ErrorIs(t, err, ErrPeerDenied)
+	assert.EqualError(t, err, `peer denied by policy: "test-profile//untrusted (enforce)" matches deny rule 0`)
+	assert.ErrorIs(t, policy.AuthorizePeer(mustParseLabel("unconfined"), ModeUnconfined), ErrPeerDenied)
+	assert.ErrorIs(t, policy.AuthorizePeer(mustParseLabel("test-profile-untrusted"), ModeEnforce), ErrPeerDenied)
+	assert.ErrorIs(t, (&PeerPolicy{}).AuthorizePeer(mustParseLabel("test-profile"), ModeEnforce), ErrPeerDenied)
+
+	assert.Error(t, (&PeerPolicy{Deny: []PeerRule{{Mode: `enforce\`}}}).Validate())
+}
+
+func TestLabelListener(t *testing.T) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "sock"), Net: "unix"})
+	require.NoError(t, err)
+
+	lookupErr := errors.New("lookup failed")
+	peers := make(chan func() (Label, Mode, error), 3)
+	peers <- func() (Label, Mode, error) { return Label{}, "", lookupErr }
+	peers <- func() (Label, Mode, error) { return mustParseLabel("unconfined"), ModeUnconfined, nil }
+	peers <- func() (Label, Mode, error) { return mustParseLabel("test-profile"), ModeEnforce, nil }
+
+	ll := NewLabelListener(l, &PeerPolicy{Allow: []PeerRule{{Profile: "test-*"}}})
+	defer ll.Close()
+	ll.peerCon = func(conn *net.UnixConn) (Label, Mode, error) {
+		return (<-peers)()
+	}
+	var rejected []error
+	ll.OnReject = func(conn *net.UnixConn, label Label, mode Mode, err error) {
+		rejected = append(rejected, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("unix", ll.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+
+	conn, err := ll.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	labelConn := conn.(*LabelConn)
+	assert.Equal(t, "test-profile", labelConn.Label.String())
+	assert.Equal(t, ModeEnforce, labelConn.Mode)
+
+	require.Len(t, rejected, 2)
+	assert.ErrorIs(t, rejected[0], lookupErr)
+	assert.ErrorIs(t, rejected[1], ErrPeerDenied)
+
+	ll.Close()
+	conn, err = ll.Accept()
+	assert.Error(t, err)
+	assert.Nil(t, conn)
+}
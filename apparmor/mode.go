@@ -0,0 +1,74 @@
+//go:build linux
+package apparmor
+
+// Mode is the mode part of a confinement string, e.g. "enforce".
+//
+// Modes not known to this package are preserved as-is.
+type Mode string
+
+const (
+	ModeEnforce    Mode = "enforce"
+	ModeComplain   Mode = "complain"
+	ModeKill       Mode = "kill"
+	ModeUnconfined Mode = "unconfined"
+	ModeUser       Mode = "user"
+	ModePrompt     Mode = "prompt"
+	// ModeMixed is reported for stacked labels whose components are in different modes
+	ModeMixed Mode = "mixed"
+)
+
+// ParseMode converts the mode returned by SplitCon() into a Mode.
+// Unknown modes are kept verbatim so that they can be formatted back losslessly.
+func ParseMode(mode string) Mode {
+	return Mode(mode)
+}
+
+func (m Mode) String() string {
+	return string(m)
+}
+
+// IsKnown returns if m is one of the modes defined in this package.
+func (m Mode) IsKnown() bool {
+	switch m {
+	case ModeEnforce, ModeComplain, ModeKill, ModeUnconfined, ModeUser, ModePrompt, ModeMixed:
+		return true
+	}
+	return false
+}
+
+// SplitConMode split a confinement string into label and typed mode.
+//
+// Unlike SplitCon(), the bare "unconfined" label is reported with ModeUnconfined.
+func SplitConMode(confinement string) (label string, mode Mode, err error) {
+	label, rawMode, err := SplitCon(confinement)
+	if err != nil {
+		return "", "", err
+	}
+	if label == labelUnconfined && rawMode == "" {
+		return label, ModeUnconfined, nil
+	}
+	return label, ParseMode(rawMode), nil
+}
+
+// FormatCon is the inverse of SplitConMode(), it formats label and mode
+// into a confinement string.
+func FormatCon(label string, mode Mode) string {
+	if mode == "" || (label == labelUnconfined && mode == ModeUnconfined) {
+		return label
+	}
+	return label + " (" + string(mode) + ")"
+}
+
+// parseCon parses a raw confinement string into a Label and Mode.
+// It is shared by the proc attr and peer socket paths.
+func parseCon(confinement string) (Label, Mode, error) {
+	rawLabel, mode, err := SplitConMode(confinement)
+	if err != nil {
+		return Label{}, "", err
+	}
+	label, err := ParseLabel(rawLabel)
+	if err != nil {
+		return Label{}, "", err
+	}
+	return label, mode, nil
+}
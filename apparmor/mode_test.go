@@ -0,0 +1,39 @@
+package apparmor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitConModeRoundTrip(t *testing.T) {
+	testCases := []struct {
+		raw   string
+		label string
+		mode  Mode
+	}{
+		{"unconfined", "unconfined", ModeUnconfined},
+		{"/path/to/executable (enforce)", "/path/to/executable", ModeEnforce},
+		{"/path/to/executable//hat (complain)", "/path/to/executable//hat", ModeComplain},
+		{"test-profile (unconfined)", "test-profile", ModeUnconfined},
+		{"a//&b (mixed)", "a//&b", ModeMixed},
+		{"test-profile (some-future-mode)", "test-profile", Mode("some-future-mode")},
+	}
+	for _, c := range testCases {
+		label, mode, err := SplitConMode(c.raw)
+		require.NoError(t, err, c.raw)
+		assert.Equal(t, c.label, label, c.raw)
+		assert.Equal(t, c.mode, mode, c.raw)
+		assert.Equal(t, c.raw, FormatCon(label, mode), c.raw)
+	}
+}
+
+func TestModeIsKnown(t *testing.T) {
+	for _, m := range []Mode{ModeEnforce, ModeComplain, ModeKill, ModeUnconfined, ModeUser, ModePrompt, ModeMixed} {
+		assert.True(t, m.IsKnown(), m)
+		assert.Equal(t, m, ParseMode(m.String()))
+	}
+	assert.False(t, ParseMode("some-future-mode").IsKnown())
+	assert.Equal(t, "some-future-mode", ParseMode("some-future-mode").String())
+}
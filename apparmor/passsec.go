@@ -0,0 +1,107 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// SCM_SECURITY is not defined by the syscall package
+const (
+	scmSecurity = 0x3
+)
+
+// ErrNoSecurityLabel is returned when a message carries no SCM_SECURITY control message,
+// for example because SO_PASSSEC is not enabled on the socket.
+var ErrNoSecurityLabel = errors.New("no security label in message")
+
+// securityOOBSize is the size of the buffer for control messages,
+// large enough for any label the kernel sends
+const securityOOBSize = 4096
+
+// EnablePassSec enables SO_PASSSEC on conn, so that the kernel attaches the
+// security label of the sender to every message received on conn.
+func EnablePassSec(conn syscall.Conn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSSEC, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// ReadMsgCon reads a message from conn into b like ReadMsgUnix() and returns the
+// confinement label and mode of its sender, as split by SplitCon().
+//
+// SO_PASSSEC must be enabled on conn with EnablePassSec(), otherwise ErrNoSecurityLabel
+// is returned along with the message. File descriptors passed with the message are closed.
+func ReadMsgCon(conn *net.UnixConn, b []byte) (n int, addr *net.UnixAddr, label string, mode string, err error) {
+	n, addr, rawCon, err := readMsgSecurity(conn, b)
+	if err != nil {
+		return n, addr, "", "", err
+	}
+	label, mode, err = SplitCon(rawCon)
+	return n, addr, label, mode, err
+}
+
+// ReadMsgConLabel is like ReadMsgCon() but returns the parsed label and mode of the sender.
+func ReadMsgConLabel(conn *net.UnixConn, b []byte) (n int, addr *net.UnixAddr, label Label, mode Mode, err error) {
+	n, addr, rawCon, err := readMsgSecurity(conn, b)
+	if err != nil {
+		return n, addr, Label{}, "", err
+	}
+	label, mode, err = parseCon(rawCon)
+	return n, addr, label, mode, err
+}
+
+func readMsgSecurity(conn *net.UnixConn, b []byte) (int, *net.UnixAddr, string, error) {
+	oob := make([]byte, securityOOBSize)
+	n, oobn, flags, addr, err := conn.ReadMsgUnix(b, oob)
+	if err != nil {
+		return n, addr, "", err
+	}
+	rawCon, err := parseSecurityControlMessage(oob[:oobn])
+	if err == nil && flags&syscall.MSG_CTRUNC != 0 {
+		err = errors.New("control message truncated")
+	}
+	return n, addr, rawCon, err
+}
+
+// parseSecurityControlMessage returns the content of the SCM_SECURITY control message in oob.
+func parseSecurityControlMessage(oob []byte) (string, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse control messages: %v", err)
+	}
+
+	var rawCon string
+	found := false
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_SOCKET {
+			continue
+		}
+		switch msg.Header.Type {
+		case scmSecurity:
+			rawCon = strings.TrimRight(string(msg.Data), "\x00")
+			found = true
+		case syscall.SCM_RIGHTS:
+			if fds, err := syscall.ParseUnixRights(&msg); err == nil {
+				for _, fd := range fds {
+					syscall.Close(fd)
+				}
+			}
+		}
+	}
+	if !found {
+		return "", ErrNoSecurityLabel
+	}
+	return rawCon, nil
+}
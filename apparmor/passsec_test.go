@@ -0,0 +1,81 @@
+package apparmor
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func securityControlMessage(data string) []byte {
+	b := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = syscall.SOL_SOCKET
+	h.Type = scmSecurity
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(b[syscall.CmsgLen(0):], data)
+	return b
+}
+
+func TestParseSecurityControlMessage(t *testing.T) {
+	_, err := parseSecurityControlMessage(nil)
+	assert.ErrorIs(t, err, ErrNoSecurityLabel)
+
+	rawCon, err := parseSecurityControlMessage(securityControlMessage("test-profile (enforce)\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, "test-profile (enforce)", rawCon)
+
+	// passed file descriptors are closed
+	fd, err := syscall.Dup(0)
+	require.NoError(t, err)
+	oob := append(syscall.UnixRights(fd), securityControlMessage("unconfined")...)
+	rawCon, err = parseSecurityControlMessage(oob)
+	require.NoError(t, err)
+	assert.Equal(t, "unconfined", rawCon)
+	_, err = syscall.Dup(fd)
+	assert.ErrorIs(t, err, syscall.EBADF)
+
+	_, err = parseSecurityControlMessage(syscall.UnixRights())
+	assert.ErrorIs(t, err, ErrNoSecurityLabel)
+}
+
+func TestReadMsgCon(t *testing.T) {
+	a, b := socketPair(t, syscall.SOCK_DGRAM)
+	defer a.Close()
+	defer b.Close()
+
+	buf := make([]byte, 16)
+
+	_, err := a.Write([]byte("test"))
+	require.NoError(t, err)
+	n, _, _, _, err := ReadMsgCon(b, buf)
+	assert.ErrorIs(t, err, ErrNoSecurityLabel)
+	assert.Equal(t, "test", string(buf[:n]))
+
+	require.NoError(t, EnablePassSec(b))
+	_, err = a.Write([]byte("test2"))
+	require.NoError(t, err)
+	n, _, rawCon, err := readMsgSecurity(b, buf)
+	assert.Equal(t, "test2", string(buf[:n]))
+	if err == ErrNoSecurityLabel {
+		t.Skipf("no LSM provides SCM_SECURITY")
+	}
+	require.NoError(t, err)
+
+	// the sender is this process
+	rawSelf, err := GetProcAttrRaw(gettid(), "current")
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimRight(rawSelf, "\x00\n"), rawCon)
+
+	_, err = a.Write([]byte("test3"))
+	require.NoError(t, err)
+	expLabel, expMode, expErr := SplitCon(rawCon)
+	n, _, label, mode, err := ReadMsgCon(b, buf)
+	assert.Equal(t, "test3", string(buf[:n]))
+	assert.Equal(t, expErr, err)
+	assert.Equal(t, expLabel, label)
+	assert.Equal(t, expMode, mode)
+}
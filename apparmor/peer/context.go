@@ -0,0 +1,76 @@
+//go:build linux
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+)
+
+// ErrNoPeerInfo is returned by FromContext() when the context carries no peer information.
+var ErrNoPeerInfo = errors.New("no apparmor peer information in context")
+
+// Info is the confinement of the peer of a connection.
+type Info struct {
+	Label apparmor.Label
+	Mode  apparmor.Mode
+}
+
+type contextKey struct{}
+
+type contextValue struct {
+	info Info
+	err  error
+}
+
+// peerCon is replaced in tests
+var peerCon = apparmor.PeerConOf
+
+// Lookup returns the confinement of the peer of conn.
+//
+// If conn is a *apparmor.LabelConn, the label recorded when it was accepted is returned,
+// otherwise conn must implement syscall.Conn, like *net.UnixConn does.
+func Lookup(conn net.Conn) (Info, error) {
+	switch c := conn.(type) {
+	case *apparmor.LabelConn:
+		return Info{Label: c.Label, Mode: c.Mode}, nil
+	case syscall.Conn:
+		label, mode, err := peerCon(c)
+		if err != nil {
+			return Info{}, err
+		}
+		return Info{Label: label, Mode: mode}, nil
+	}
+	return Info{}, errors.New("connection does not expose its socket")
+}
+
+// NewContext returns a copy of ctx carrying info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, contextValue{info: info})
+}
+
+// FromContext returns the peer information stored in ctx by NewContext() or ConnContext().
+//
+// If ConnContext() failed to look up the peer, the lookup error is returned.
+func FromContext(ctx context.Context) (Info, error) {
+	v, ok := ctx.Value(contextKey{}).(contextValue)
+	if !ok {
+		return Info{}, ErrNoPeerInfo
+	}
+	return v.info, v.err
+}
+
+// ConnContext looks up the peer of c and stores it in the returned context,
+// it can be used as http.Server.ConnContext:
+//
+//	server := &http.Server{ConnContext: peer.ConnContext}
+//
+// A failed lookup does not prevent the connection from being served,
+// the error is returned by FromContext() instead.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	info, err := Lookup(c)
+	return context.WithValue(ctx, contextKey{}, contextValue{info: info, err: err})
+}
@@ -0,0 +1,62 @@
+//go:build linux
+package peer
+
+import (
+	"context"
+	"net"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+)
+
+// AuthType is the value returned by AuthInfo.AuthType().
+const AuthType = "apparmor"
+
+// AuthInfo is the peer information produced by a handshake of a Handshaker.
+type AuthInfo struct {
+	Info
+}
+
+func (AuthInfo) AuthType() string {
+	return AuthType
+}
+
+// Handshaker authorizes the peer of unix socket connections by its label when
+// an RPC framework sets up a connection, on the client and on the server side.
+//
+// Handshaker does not secure the connection, it only authenticates the peer
+// using the kernel. It does not implement the credentials interfaces of any
+// RPC framework, which require their own types, but can back an adapter to them.
+type Handshaker struct {
+	authorizer apparmor.PeerAuthorizer
+}
+
+// NewHandshaker returns a Handshaker that accepts peers allowed by authorizer.
+func NewHandshaker(authorizer apparmor.PeerAuthorizer) *Handshaker {
+	return &Handshaker{authorizer: authorizer}
+}
+
+// ServerHandshake authorizes the client connected on conn.
+// conn is returned unchanged if the client is allowed.
+func (h *Handshaker) ServerHandshake(conn net.Conn) (net.Conn, AuthInfo, error) {
+	return h.handshake(conn)
+}
+
+// ClientHandshake authorizes the server conn is connected to, authority is ignored.
+// conn is returned unchanged if the server is allowed.
+func (h *Handshaker) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, AuthInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, AuthInfo{}, err
+	}
+	return h.handshake(conn)
+}
+
+func (h *Handshaker) handshake(conn net.Conn) (net.Conn, AuthInfo, error) {
+	info, err := Lookup(conn)
+	if err != nil {
+		return nil, AuthInfo{}, err
+	}
+	if err := h.authorizer.AuthorizePeer(info.Label, info.Mode); err != nil {
+		return nil, AuthInfo{}, err
+	}
+	return conn, AuthInfo{Info: info}, nil
+}
@@ -0,0 +1,29 @@
+//go:build linux
+package peer
+
+import (
+	"net/http"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+)
+
+// Middleware returns a middleware that only passes requests whose peer, as stored
+// by ConnContext(), is allowed by authorizer, and responds with 403 Forbidden otherwise.
+//
+// Requests without peer information are rejected as well. Wrap individual handlers
+// to enforce a different policy per route.
+func Middleware(authorizer apparmor.PeerAuthorizer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info, err := FromContext(r.Context())
+			if err == nil {
+				err = authorizer.AuthorizePeer(info.Label, info.Mode)
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
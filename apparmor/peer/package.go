@@ -0,0 +1,3 @@
+// Package peer exposes the AppArmor label of the peer of unix socket connections
+// to HTTP handlers and RPC frameworks.
+package peer
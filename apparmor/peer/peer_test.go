@@ -0,0 +1,114 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/eternal-flame-AD/go-apparmor/apparmor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseLabel(raw string) apparmor.Label {
+	label, err := apparmor.ParseLabel(raw)
+	if err != nil {
+		panic(err)
+	}
+	return label
+}
+
+// fakePeer replaces the peer lookup for the duration of the test.
+func fakePeer(t *testing.T, label string, mode apparmor.Mode, err error) {
+	orig := peerCon
+	peerCon = func(conn syscall.Conn) (apparmor.Label, apparmor.Mode, error) {
+		if err != nil {
+			return apparmor.Label{}, "", err
+		}
+		return mustParseLabel(label), mode, nil
+	}
+	t.Cleanup(func() { peerCon = orig })
+}
+
+var testPolicy = &apparmor.PeerPolicy{
+	Allow: []apparmor.PeerRule{{Profile: "test-profile", Mode: "enforce"}},
+}
+
+func TestConnContext(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// net.Pipe does not expose a socket
+	_, err := FromContext(ConnContext(context.Background(), a))
+	assert.Error(t, err)
+
+	_, err = FromContext(context.Background())
+	assert.ErrorIs(t, err, ErrNoPeerInfo)
+
+	conn := &apparmor.LabelConn{Label: mustParseLabel("test-profile"), Mode: apparmor.ModeEnforce}
+	info, err := FromContext(ConnContext(context.Background(), conn))
+	require.NoError(t, err)
+	assert.Equal(t, "test-profile", info.Label.String())
+	assert.Equal(t, apparmor.ModeEnforce, info.Mode)
+
+	fakePeer(t, "test-profile//test-hat", apparmor.ModeComplain, nil)
+	info, err = FromContext(ConnContext(context.Background(), &net.UnixConn{}))
+	require.NoError(t, err)
+	assert.Equal(t, "test-profile//test-hat", info.Label.String())
+	assert.Equal(t, apparmor.ModeComplain, info.Mode)
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(testPolicy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := FromContext(r.Context())
+		require.NoError(t, err)
+		w.Write([]byte(info.Label.String()))
+	}))
+
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		return w
+	}
+
+	w := serve(NewContext(context.Background(), Info{Label: mustParseLabel("test-profile"), Mode: apparmor.ModeEnforce}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test-profile", w.Body.String())
+
+	w = serve(NewContext(context.Background(), Info{Label: mustParseLabel("test-profile"), Mode: apparmor.ModeComplain}))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(context.Background())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandshaker(t *testing.T) {
+	h := NewHandshaker(testPolicy)
+	conn := &net.UnixConn{}
+
+	fakePeer(t, "test-profile", apparmor.ModeEnforce, nil)
+	got, authInfo, err := h.ServerHandshake(conn)
+	require.NoError(t, err)
+	assert.Equal(t, conn, got)
+	assert.Equal(t, AuthType, authInfo.AuthType())
+	assert.Equal(t, "test-profile", authInfo.Label.String())
+
+	fakePeer(t, "unconfined", apparmor.ModeUnconfined, nil)
+	_, _, err = h.ClientHandshake(context.Background(), "", conn)
+	assert.ErrorIs(t, err, apparmor.ErrPeerDenied)
+
+	lookupErr := errors.New("lookup failed")
+	fakePeer(t, "", "", lookupErr)
+	_, _, err = h.ServerHandshake(conn)
+	assert.ErrorIs(t, err, lookupErr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = h.ClientHandshake(ctx, "", conn)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -2,46 +2,83 @@
 package apparmor
 
 import (
-	"errors"
-	"runtime"
+	"net"
 	"strings"
 	"syscall"
 	"unsafe"
 )
 
+// AAGetPeerCon returns the confinement label and mode of the peer of the socket fd.
+// functional replica of aa_getpeercon() in libapparmor
 func AAGetPeerCon(fd int) (label string, mode string, err error) {
-	bufSize := uint32(64)
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	rawPeerCon, err := getPeerConRaw(fd)
+	if err != nil {
+		return "", "", err
+	}
+	return SplitCon(rawPeerCon)
+}
+
+// AAGetPeerConLabel returns the parsed confinement label and mode of the peer
+// of the socket fd.
+func AAGetPeerConLabel(fd int) (label Label, mode Mode, err error) {
+	rawPeerCon, err := getPeerConRaw(fd)
+	if err != nil {
+		return Label{}, "", err
+	}
+	return parseCon(rawPeerCon)
+}
 
+// PeerConOf returns the parsed confinement label and mode of the peer of conn,
+// for example a *net.UnixConn.
+//
+// The socket is accessed through conn.SyscallConn(), so unlike calling File() on a
+// net.Conn, the descriptor is neither duplicated nor switched to blocking mode.
+func PeerConOf(conn syscall.Conn) (label Label, mode Mode, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return Label{}, "", err
+	}
 	var rawPeerCon string
+	var peerErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		rawPeerCon, peerErr = getPeerConRaw(int(fd))
+	}); err != nil {
+		return Label{}, "", err
+	}
+	if peerErr != nil {
+		return Label{}, "", peerErr
+	}
+	return parseCon(rawPeerCon)
+}
 
-	maxTries := 5
-	for i := 0; i < maxTries; i++ {
+// UnixPeerCon returns the parsed confinement label and mode of the peer of the
+// unix socket conn, see PeerConOf().
+func UnixPeerCon(conn *net.UnixConn) (label Label, mode Mode, err error) {
+	return PeerConOf(conn)
+}
 
+func getPeerConRaw(fd int) (string, error) {
+	bufSize := uint32(64)
+	for {
 		buf := make([]byte, bufSize)
-
-		bufSizePtr := unsafe.Pointer(&bufSize)
+		size := bufSize
 
 		_, _, err := syscall.Syscall6(
 			syscall.SYS_GETSOCKOPT, uintptr(fd), syscall.SOL_SOCKET, syscall.SO_PEERSEC,
-			uintptr(unsafe.Pointer(&buf[0])), uintptr(bufSizePtr), 0)
-		// bufSize should have updated value now
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+		// size should have updated value now
 
 		if err == 0 {
-			rawPeerCon = string(buf[:bufSize])
-			rawPeerCon = strings.TrimSuffix(rawPeerCon, "\x00")
-			return SplitCon(rawPeerCon)
+			return strings.TrimSuffix(string(buf[:size]), "\x00"), nil
 		} else if err != syscall.ERANGE {
-			return "", "", err
+			return "", err
 		}
 
-		runtime.KeepAlive(buf)
-		runtime.KeepAlive(bufSize)
-
-		// just to be sure
-		bufSize++
-
+		// the kernel reports the required size, grow anyway in case it does not
+		if size > bufSize {
+			bufSize = size
+		} else {
+			bufSize *= 2
+		}
 	}
-	return "", "", errors.New("too many retries but buffer is still too small")
 }
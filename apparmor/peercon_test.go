@@ -0,0 +1,55 @@
+package apparmor
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func socketPair(t *testing.T, sockType int) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, sockType|syscall.SOCK_CLOEXEC, 0)
+	require.NoError(t, err)
+
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		conn, err := net.FileConn(f)
+		f.Close()
+		require.NoError(t, err)
+		conns[i] = conn.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestPeerConOf(t *testing.T) {
+	a, b := socketPair(t, syscall.SOCK_STREAM)
+	defer a.Close()
+	defer b.Close()
+
+	label, mode, err := PeerConOf(a)
+	if err == syscall.ENOPROTOOPT {
+		t.Skipf("SO_PEERSEC not supported")
+	}
+
+	rawConn, rawErr := a.SyscallConn()
+	require.NoError(t, rawErr)
+	var rawPeerCon string
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		rawPeerCon, rawErr = getPeerConRaw(int(fd))
+	}))
+	require.NoError(t, rawErr)
+	expLabel, expMode, expErr := parseCon(rawPeerCon)
+	assert.Equal(t, expErr, err)
+	assert.Equal(t, expLabel, label)
+	assert.Equal(t, expMode, mode)
+
+	// both ends belong to this process
+	label, mode, err = UnixPeerCon(b)
+	assert.Equal(t, expErr, err)
+	assert.Equal(t, expLabel, label)
+	assert.Equal(t, expMode, mode)
+}
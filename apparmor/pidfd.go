@@ -0,0 +1,194 @@
+//go:build linux
+package apparmor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrProcessExited is returned when the process referred to by a pidfd has exited
+// before its attributes could be read.
+var ErrProcessExited = errors.New("process has exited")
+
+// ErrProcessNotInNamespace is returned when the process referred to by a pidfd
+// has no pid in the pid namespace of the caller, so its proc directory cannot be found.
+var ErrProcessNotInNamespace = errors.New("process is outside the pid namespace")
+
+// PidfdOpen returns a pidfd referring to the process pid.
+// The returned file descriptor has close-on-exec set and should be closed by the caller.
+//
+// Opening a pidfd by pid is itself racy unless pid is a child of the caller
+// that has not been waited on yet.
+func PidfdOpen(pid int) (int, error) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// pidfdPid returns the pid the pidfd refers to, as reported in /proc/self/fdinfo.
+//
+// The kernel reports -1 once the process has been reaped, from that point on the
+// pid may be reused. Older kernels keep reporting the stale pid, so liveness must
+// be checked with pidfdAlive() instead.
+func pidfdPid(pidfd int) (int, error) {
+	f, err := os.Open(path.Join("/proc/self/fdinfo", strconv.Itoa(pidfd)))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parsePidfdInfo(f, pidfd)
+}
+
+func parsePidfdInfo(r io.Reader, pidfd int) (int, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if value, ok := cutPrefix(scanner.Text(), "Pid:"); ok {
+			pid, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return 0, fmt.Errorf("cannot parse pidfd info: %v", err)
+			}
+			if pid < 0 {
+				return 0, ErrProcessExited
+			}
+			if pid == 0 {
+				return 0, ErrProcessNotInNamespace
+			}
+			return pid, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("fd %d is not a pidfd", pidfd)
+}
+
+// pidfdAlive returns ErrProcessExited if the process referred to by pidfd has been reaped.
+// Sending signal 0 performs no action, but fails with ESRCH once the pid is released.
+func pidfdAlive(pidfd int) error {
+	_, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(pidfd), 0, 0, 0, 0, 0)
+	switch errno {
+	case 0, syscall.EPERM:
+		// EPERM means the process exists but may not be signalled by the caller
+		return nil
+	case syscall.ESRCH:
+		return ErrProcessExited
+	default:
+		return errno
+	}
+}
+
+// GetPidfdAttrRaw return the raw confinement string of the process referred to by pidfd.
+//
+// The proc directory of the process is opened and validated against the pidfd
+// before the attr is read relative to it, so a recycled pid can never be
+// mistaken for the original process. ErrProcessExited is returned if the process
+// has been reaped before or during the read, and ErrProcessNotInNamespace if the
+// process is not visible in the pid namespace of the caller.
+func GetPidfdAttrRaw(pidfd int, attr string) (string, error) {
+	pid, err := pidfdPid(pidfd)
+	if err != nil {
+		return "", err
+	}
+	procFd, err := syscall.Open(path.Join("/proc", strconv.Itoa(pid)),
+		syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return "", ErrProcessExited
+	} else if err != nil {
+		return "", err
+	}
+	defer syscall.Close(procFd)
+
+	// if the process is still not reaped, its pid was not reused and procFd refers to it
+	if err := pidfdAlive(pidfd); err != nil {
+		return "", err
+	}
+
+	r, err := readAttrAt(procFd, path.Join("attr/apparmor", attr))
+	if os.IsNotExist(err) {
+		r, err = readAttrAt(procFd, path.Join("attr", attr))
+	}
+	if errors.Is(err, syscall.ESRCH) {
+		return "", ErrProcessExited
+	} else if err != nil {
+		return "", err
+	}
+
+	if err := pidfdAlive(pidfd); err != nil {
+		return "", err
+	}
+	return r, nil
+}
+
+func readAttrAt(dirFd int, name string) (string, error) {
+	fd, err := syscall.Openat(dirFd, name, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return "", &os.PathError{Op: "openat", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	r, err := io.ReadAll(f)
+	if err != nil {
+		return "", unwrapPathError(err)
+	}
+	return string(r), nil
+}
+
+// AAGetPidfdCon returns the confinement label and mode of the process referred to by pidfd.
+func AAGetPidfdCon(pidfd int) (label string, mode string, err error) {
+	r, err := GetPidfdAttrRaw(pidfd, "current")
+	if err != nil {
+		return "", "", err
+	}
+	return SplitCon(r)
+}
+
+// AAGetProcessCon returns the confinement label and mode of the process p.
+//
+// p must not have been waited on, as long as a child process is not reaped its
+// pid cannot be reused and the lookup is free of races.
+func AAGetProcessCon(p *os.Process) (label string, mode string, err error) {
+	r, err := processAttrRaw(p, "current")
+	if err != nil {
+		return "", "", err
+	}
+	return SplitCon(r)
+}
+
+// processConLabel returns the parsed confinement label and mode of the process p,
+// see AAGetProcessCon().
+func processConLabel(p *os.Process) (Label, Mode, error) {
+	r, err := processAttrRaw(p, "current")
+	if err != nil {
+		return Label{}, "", err
+	}
+	return parseCon(r)
+}
+
+// processAttrRaw returns the raw attr of the process p through a pidfd, see GetPidfdAttrRaw().
+func processAttrRaw(p *os.Process, attr string) (string, error) {
+	pidfd, err := PidfdOpen(p.Pid)
+	if err == syscall.ESRCH {
+		return "", ErrProcessExited
+	} else if err != nil {
+		return "", err
+	}
+	defer syscall.Close(pidfd)
+	return GetPidfdAttrRaw(pidfd, attr)
+}
+
+// cutPrefix is strings.CutPrefix, which requires go 1.20
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
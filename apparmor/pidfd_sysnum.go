@@ -0,0 +1,9 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+package apparmor
+
+// syscall numbers from the unified syscall table, shared by all architectures
+// supported by Go except mips, which offsets them by its ABI base
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
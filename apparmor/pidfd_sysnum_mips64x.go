@@ -0,0 +1,8 @@
+//go:build linux && (mips64 || mips64le)
+package apparmor
+
+// syscall numbers of the n64 ABI, offset by 5000
+const (
+	sysPidfdSendSignal = 5424
+	sysPidfdOpen       = 5434
+)
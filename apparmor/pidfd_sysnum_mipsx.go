@@ -0,0 +1,8 @@
+//go:build linux && (mips || mipsle)
+package apparmor
+
+// syscall numbers of the o32 ABI, offset by 4000
+const (
+	sysPidfdSendSignal = 4424
+	sysPidfdOpen       = 4434
+)
@@ -0,0 +1,74 @@
+package apparmor
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPidfdLookup(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+
+	pidfd, err := PidfdOpen(cmd.Process.Pid)
+	if err == syscall.ENOSYS {
+		t.Skip("pidfd_open is not supported by the kernel")
+	}
+	require.NoError(t, err)
+	defer syscall.Close(pidfd)
+
+	pid, err := pidfdPid(pidfd)
+	require.NoError(t, err)
+	assert.Equal(t, cmd.Process.Pid, pid)
+	assert.NoError(t, pidfdAlive(pidfd))
+
+	expected, errExpected := GetProcAttrRaw(cmd.Process.Pid, "current")
+	actual, errActual := GetPidfdAttrRaw(pidfd, "current")
+	if errExpected == nil {
+		assert.NoError(t, errActual)
+		assert.Equal(t, expected, actual)
+	} else {
+		assert.Error(t, errActual)
+	}
+
+	require.NoError(t, cmd.Process.Kill())
+	_, _ = cmd.Process.Wait()
+
+	assert.ErrorIs(t, pidfdAlive(pidfd), ErrProcessExited)
+	_, err = GetPidfdAttrRaw(pidfd, "current")
+	assert.ErrorIs(t, err, ErrProcessExited)
+}
+
+func TestPidfdPidNotPidfd(t *testing.T) {
+	f, err := os.Open("/proc/self/stat")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = pidfdPid(int(f.Fd()))
+	assert.Error(t, err)
+}
+
+func TestParsePidfdInfo(t *testing.T) {
+	testCases := []struct {
+		info string
+		pid  int
+		err  error
+	}{
+		{"pos:\t0\nflags:\t02000002\nPid:\t1234\n", 1234, nil},
+		{"Pid:\t-1\n", 0, ErrProcessExited},
+		{"Pid:\t0\n", 0, ErrProcessNotInNamespace},
+	}
+	for _, c := range testCases {
+		pid, err := parsePidfdInfo(strings.NewReader(c.info), 3)
+		assert.Equal(t, c.pid, pid, c.info)
+		assert.ErrorIs(t, err, c.err, c.info)
+	}
+
+	_, err := parsePidfdInfo(strings.NewReader("pos:\t0\n"), 3)
+	assert.Error(t, err)
+}
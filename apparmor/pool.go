@@ -0,0 +1,341 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrHatPoolClosed is returned when submitting jobs to a closed HatPool.
+var ErrHatPoolClosed = errors.New("hat pool is closed")
+
+// ErrHatPoolEmpty is returned when submitting jobs to a HatPool resized to zero threads.
+var ErrHatPoolEmpty = errors.New("hat pool has no threads")
+
+// HatPool keeps a bounded set of OS threads parked in a hat and runs jobs on them.
+//
+// Unlike WithHat(), which transitions a new thread into and out of the hat for
+// every call, the threads of a HatPool stay in the hat between jobs. A thread
+// only transitions back to the parent profile when the pool is shrunk or closed,
+// and is killed by the scheduler if it cannot. A thread a job panicked on is
+// always killed and replaced by a new one.
+//
+// Jobs must not spawn goroutines expecting them to be confined, nor keep state
+// around that should not be visible to later jobs running in the same hat.
+type HatPool struct {
+	hat   string
+	magic func() uint64
+
+	jobs chan hatPoolJob
+	quit chan chan error
+	done chan struct{}
+
+	mu   sync.Mutex
+	size int
+	// live is the number of running workers, starting the number of workers
+	// entering the hat, and quitting the number asked to retire by Resize()
+	live     int
+	starting int
+	quitting int
+	// empty is closed when the pool is shrunk to zero threads
+	empty chan struct{}
+	// lost is closed and replaced when a worker exits without being asked to
+	lost    chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+	exitErr error
+
+	setHat func(hat string, magic uint64) error
+}
+
+type hatPoolJob struct {
+	fn     func()
+	result chan error
+}
+
+// NewHatPool starts a HatPool of size threads parked in hat.
+//
+// Magic is a function that when called should return the same non-zero value as the
+// magic token for transitioning out of the hat, see WithHat().
+func NewHatPool(hat string, magic func() uint64, size int) (*HatPool, error) {
+	return newHatPool(hat, magic, size, SetHat)
+}
+
+func newHatPool(hat string, magic func() uint64, size int, setHat func(hat string, magic uint64) error) (*HatPool, error) {
+	if hat == "" {
+		return nil, errors.New("NewHatPool() does not accept empty string")
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid pool size(%d): must be positive", size)
+	}
+
+	p := &HatPool{
+		hat:    hat,
+		magic:  magic,
+		jobs:   make(chan hatPoolJob),
+		quit:   make(chan chan error),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+		setHat: setHat,
+	}
+	if err := p.Resize(size); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Do runs fn on one of the pool's threads and waits for it to return.
+//
+// If fn panics, the panic is recovered and returned as a *HatPanicError. Like WithHat(),
+// the thread fn panicked on is never reused: it attempts to leave the hat to fill in
+// ExitErr and is then killed, and a new thread takes its place in the pool.
+// If the new thread cannot enter the hat, the pool shrinks by one thread.
+//
+// ErrHatPoolEmpty is returned if the pool has been resized to zero threads,
+// including while Do is waiting for an idle thread.
+func (p *HatPool) Do(fn func()) error {
+	p.mu.Lock()
+	closed, size, empty := p.closed, p.size, p.empty
+	p.mu.Unlock()
+	if closed {
+		return ErrHatPoolClosed
+	}
+	if size == 0 {
+		return ErrHatPoolEmpty
+	}
+
+	job := hatPoolJob{fn: fn, result: make(chan error, 1)}
+	select {
+	case p.jobs <- job:
+	case <-p.done:
+		return ErrHatPoolClosed
+	case <-empty:
+		return ErrHatPoolEmpty
+	}
+	return <-job.result
+}
+
+// Size returns the number of threads in the pool.
+func (p *HatPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// Resize grows or shrinks the pool to size threads.
+//
+// Shrinking waits for enough threads to finish their current job and transition
+// back to the parent profile, and returns the first error encountered while leaving
+// the hat. A job shrinking the pool it runs on deadlocks if no other thread becomes idle.
+func (p *HatPool) Resize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("invalid pool size(%d): must not be negative", size)
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrHatPoolClosed
+	}
+
+	p.setSize(size)
+	for p.live+p.starting-p.quitting < p.size {
+		// the lock is released while the new thread enters the hat
+		p.starting++
+		p.wg.Add(1)
+		p.mu.Unlock()
+
+		ready := make(chan error)
+		go p.worker(ready)
+		err := <-ready
+
+		p.mu.Lock()
+		p.starting--
+		if p.closed {
+			p.mu.Unlock()
+			return ErrHatPoolClosed
+		}
+		if err != nil {
+			p.setSize(p.live + p.starting - p.quitting)
+			p.mu.Unlock()
+			return &HatError{Hat: p.hat, Err: err}
+		}
+		p.live++
+	}
+
+	// the lock is released while signalling, which waits for busy threads to finish their jobs
+	var firstErr error
+	for p.live-p.quitting > p.size {
+		p.quitting++
+		lost := p.lost
+		p.mu.Unlock()
+
+		exited := make(chan error, 1)
+		select {
+		case p.quit <- exited:
+			if err := <-exited; err != nil && firstErr == nil {
+				firstErr = err
+			}
+			p.mu.Lock()
+		case <-lost:
+			// the worker count changed, check again
+			p.mu.Lock()
+			p.quitting--
+		case <-p.done:
+			// Close() retires the remaining threads
+			return ErrHatPoolClosed
+		}
+	}
+	p.mu.Unlock()
+	return firstErr
+}
+
+// setSize sets the target number of threads, closing empty when it drops to zero.
+// The caller must hold p.mu.
+func (p *HatPool) setSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	if p.size == 0 && size > 0 {
+		p.empty = make(chan struct{})
+	} else if p.size > 0 && size == 0 {
+		close(p.empty)
+	}
+	p.size = size
+}
+
+// Close retires all threads of the pool and waits for them to transition back
+// to the parent profile. It returns the first error encountered while leaving the hat.
+func (p *HatPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.size = 0
+	close(p.done)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitErr
+}
+
+func (p *HatPool) worker(ready chan<- error) {
+	defer p.wg.Done()
+
+	if err := p.enter(); err != nil {
+		// the thread is left locked in an unknown state, let the scheduler kill it
+		ready <- err
+		return
+	}
+	ready <- nil
+
+	for {
+		select {
+		case job := <-p.jobs:
+			if panicErr := runPoolJob(p.hat, job.fn); panicErr != nil {
+				// try to leave the hat to report whether the thread was still in a sane state,
+				// but never reuse a thread fn panicked on, let the scheduler kill it
+				panicErr.ExitErr = p.leave()
+				p.replace()
+				job.result <- panicErr
+				return
+			}
+			job.result <- nil
+		case exited := <-p.quit:
+			err := p.retire()
+			p.mu.Lock()
+			p.live--
+			p.quitting--
+			p.mu.Unlock()
+			exited <- err
+			return
+		case <-p.done:
+			if err := p.retire(); err != nil {
+				p.mu.Lock()
+				if p.exitErr == nil {
+					p.exitErr = err
+				}
+				p.mu.Unlock()
+			}
+			return
+		}
+	}
+}
+
+func (p *HatPool) enter() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+		}
+	}()
+	return p.setHat(p.hat, p.magic())
+}
+
+// replace starts a new worker in place of one whose thread is killed after a panic.
+// If the new thread cannot enter the hat, the pool shrinks by one thread.
+func (p *HatPool) replace() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	ready := make(chan error)
+	go p.worker(ready)
+	if err := <-ready; err == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.live--
+	if p.live+p.starting-p.quitting < p.size {
+		p.setSize(p.live + p.starting - p.quitting)
+	}
+	// wake up Resize() if it waits for this worker to retire
+	close(p.lost)
+	p.lost = make(chan struct{})
+}
+
+// leave transitions the thread back to the parent profile, see leaveHat().
+func (p *HatPool) leave() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+		}
+	}()
+	return p.setHat("", p.magic())
+}
+
+// retire transitions the thread back to the parent profile and makes it reusable.
+// If that fails, the thread stays locked and is killed when the worker returns.
+func (p *HatPool) retire() error {
+	if err := p.leave(); err != nil {
+		return &HatError{Hat: p.hat, Exit: true, Err: err}
+	}
+	// call twice because we called SetHat() twice
+	runtime.UnlockOSThread()
+	runtime.UnlockOSThread()
+	return nil
+}
+
+func runPoolJob(hat string, fn func()) (panicErr *HatPanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr = &HatPanicError{Hat: hat, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	fn()
+	return nil
+}
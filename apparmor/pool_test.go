@@ -0,0 +1,217 @@
+package apparmor
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// benchHat returns the hat to benchmark with.
+// The benchmark binary must be confined by a profile that has the hat in APPARMOR_TEST_HAT.
+func benchHat(b *testing.B) string {
+	hat := os.Getenv("APPARMOR_TEST_HAT")
+	if hat == "" {
+		b.Skipf("APPARMOR_TEST_HAT not set, skipping")
+	}
+	return hat
+}
+
+func testMagic() uint64 {
+	// for testing only
+	return 12345
+}
+
+func TestNewHatPoolInvalid(t *testing.T) {
+	_, err := NewHatPool("", testMagic, 1)
+	assert.Error(t, err)
+	_, err = NewHatPool("test-hat", testMagic, 0)
+	assert.Error(t, err)
+}
+
+func TestRunPoolJob(t *testing.T) {
+	ran := false
+	require.Nil(t, runPoolJob("test-hat", func() { ran = true }))
+	assert.True(t, ran)
+
+	panicErr := runPoolJob("test-hat", func() { panic("test") })
+	require.NotNil(t, panicErr)
+	assert.Equal(t, "test", panicErr.Value)
+	assert.NoError(t, panicErr.ExitErr)
+	assert.EqualError(t, panicErr, `panic in hat "test-hat": test`)
+}
+
+// fakeSetHat mimics SetHat() without transitioning, for testing the pool bookkeeping.
+func fakeSetHat(hat string, magic uint64) error {
+	runtime.LockOSThread()
+	return nil
+}
+
+func TestHatPoolResize(t *testing.T) {
+	pool, err := newHatPool("test-hat", testMagic, 1, fakeSetHat)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	jobErr := make(chan error, 1)
+	go func() {
+		jobErr <- pool.Do(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	resized := make(chan error, 1)
+	go func() { resized <- pool.Resize(0) }()
+
+	// shrinking waits for the busy thread without blocking the pool
+	assert.Eventually(t, func() bool { return pool.Size() == 0 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, pool.Do(func() {}), ErrHatPoolEmpty)
+	select {
+	case <-resized:
+		t.Fatal("Resize() returned before the thread left the hat")
+	default:
+	}
+
+	close(release)
+	assert.NoError(t, <-jobErr)
+	assert.NoError(t, <-resized)
+
+	require.NoError(t, pool.Resize(2))
+	assert.Equal(t, 2, pool.Size())
+	ran := false
+	assert.NoError(t, pool.Do(func() { ran = true }))
+	assert.True(t, ran)
+
+	require.NoError(t, pool.Close())
+	assert.ErrorIs(t, pool.Do(func() {}), ErrHatPoolClosed)
+	assert.ErrorIs(t, pool.Resize(1), ErrHatPoolClosed)
+}
+
+func TestHatPoolResizeEnterUnlocked(t *testing.T) {
+	var entering, release chan struct{}
+	pool, err := newHatPool("test-hat", testMagic, 1, func(hat string, magic uint64) error {
+		runtime.LockOSThread()
+		if hat != "" && entering != nil {
+			close(entering)
+			<-release
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	entering, release = make(chan struct{}), make(chan struct{})
+	resized := make(chan error, 1)
+	go func() { resized <- pool.Resize(2) }()
+	<-entering
+
+	// the pool stays usable while the new thread enters the hat
+	assert.Equal(t, 2, pool.Size())
+	assert.NoError(t, pool.Do(func() {}))
+
+	close(release)
+	assert.NoError(t, <-resized)
+}
+
+func TestHatPoolResizeExitError(t *testing.T) {
+	pool, err := newHatPool("test-hat", testMagic, 1, func(hat string, magic uint64) error {
+		runtime.LockOSThread()
+		if hat == "" {
+			return syscall.EPERM
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	err = pool.Resize(0)
+	var hatErr *HatError
+	require.ErrorAs(t, err, &hatErr)
+	assert.True(t, hatErr.Exit)
+	assert.ErrorIs(t, err, syscall.EPERM)
+}
+
+func TestHatPoolPanic(t *testing.T) {
+	pool, err := newHatPool("test-hat", testMagic, 1, fakeSetHat)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	var panicTid int
+	err = pool.Do(func() {
+		panicTid = gettid()
+		panic("test")
+	})
+	var panicErr *HatPanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "test", panicErr.Value)
+	assert.NoError(t, panicErr.ExitErr)
+
+	// the thread is replaced instead of reused
+	assert.Equal(t, 1, pool.Size())
+	var tid int
+	require.NoError(t, pool.Do(func() { tid = gettid() }))
+	assert.NotEqual(t, panicTid, tid)
+	assert.NoError(t, pool.Resize(0))
+}
+
+func TestHatPoolPanicReplaceError(t *testing.T) {
+	entered := 0
+	pool, err := newHatPool("test-hat", testMagic, 1, func(hat string, magic uint64) error {
+		runtime.LockOSThread()
+		if hat == "" {
+			return nil
+		}
+		// only the first thread can enter the hat
+		entered++
+		if entered > 1 {
+			return syscall.EACCES
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	err = pool.Do(func() { panic("test") })
+	var panicErr *HatPanicError
+	require.ErrorAs(t, err, &panicErr)
+
+	assert.Equal(t, 0, pool.Size())
+	assert.ErrorIs(t, pool.Do(func() {}), ErrHatPoolEmpty)
+	assert.NoError(t, pool.Resize(0))
+}
+
+func BenchmarkWithHat(b *testing.B) {
+	hat := benchHat(b)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := WithHat(hat, testMagic, func() {}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkHatPool(b *testing.B) {
+	hat := benchHat(b)
+	pool, err := NewHatPool(hat, testMagic, runtime.GOMAXPROCS(0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := pool.Do(func() {}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
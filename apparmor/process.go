@@ -0,0 +1,189 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProcessConfineError is returned by ConfineProcess() and StackProcess() when
+// some threads of the process do not carry the new label after the transition.
+type ProcessConfineError struct {
+	Profile string
+	// Threads maps the tid of each thread that could not be confined
+	// to its current label, or the error encountered reading it.
+	Threads map[int]string
+	// Err is the first error encountered transitioning a thread, if any
+	Err error
+}
+
+func (e *ProcessConfineError) Error() string {
+	tids := make([]int, 0, len(e.Threads))
+	for tid := range e.Threads {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cannot confine %d threads to %q:", len(tids), e.Profile)
+	for _, tid := range tids {
+		fmt.Fprintf(&b, " %d=%q", tid, e.Threads[tid])
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *ProcessConfineError) Unwrap() error {
+	return e.Err
+}
+
+// ConfineProcess changes the profile of every thread of the process to profile
+// using AAChangeProfile(), then verifies that each thread listed in
+// /proc/self/task carries the new label.
+//
+// AppArmor transitions are per-thread, so the runtime threads are reached by parking
+// goroutines locked to their threads until the runtime has to create a new one, and
+// transitioning each of them. Threads that fail to transition are retired.
+//
+// Threads not running goroutines, like the runtime's sysmon and template threads,
+// and threads locked by other goroutines or blocked in syscalls cannot be reached.
+// They are reported in a *ProcessConfineError along with their current label, so on
+// a typical program ConfineProcess returns an error even though the threads running
+// goroutines at the time of the call are confined.
+//
+// ConfineProcess is therefore not a privilege drop: threads the runtime creates later
+// may be cloned from an unreached thread, e.g. sysmon, and run goroutines unconfined.
+// Use SelfConfine() if every thread must carry the label.
+//
+// The transition is one-way. If the calling thread cannot be transitioned,
+// the error is returned and no other thread is touched.
+func ConfineProcess(profile string) error {
+	if profile == "" {
+		return errors.New("ConfineProcess() does not accept empty string")
+	}
+	return transitionProcess(profile, AAChangeProfile, Label.Equal)
+}
+
+// StackProcess is like ConfineProcess() but stacks profile on top of the current
+// confinement of every thread using AAStackProfile().
+func StackProcess(profile string) error {
+	if profile == "" {
+		return errors.New("StackProcess() does not accept empty string")
+	}
+	return transitionProcess(profile, AAStackProfile, Label.Contains)
+}
+
+func transitionProcess(profile string, transition func(string) error, check func(cur Label, target Label) bool) error {
+	target, err := ParseLabel(profile)
+	if err != nil {
+		return err
+	}
+
+	// transitionThread transitions the current thread unless it already carries the label,
+	// as a profile usually has no rule to change to itself.
+	transitionThread := func() error {
+		if curLabel, _, err := AAGetConLabel(); err == nil && check(curLabel, target) {
+			return nil
+		}
+		return transition(profile)
+	}
+
+	runtime.LockOSThread()
+	if err := transitionThread(); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	runtime.UnlockOSThread()
+
+	before, err := listTasks()
+	if err != nil {
+		return err
+	}
+	pending := make(map[int]bool, len(before))
+	for _, tid := range before {
+		pending[tid] = true
+	}
+
+	type captured struct {
+		tid int
+		err error
+	}
+	results := make(chan captured)
+	release := make(chan struct{})
+	var firstErr error
+
+	// each parked goroutine holds on to its thread, so the next one is scheduled
+	// on another idle thread, until the runtime runs out of idle threads and creates
+	// a new one, which is confined as well.
+	for fresh := false; !fresh && len(pending) > 0; {
+		go func() {
+			runtime.LockOSThread()
+			err := transitionThread()
+			results <- captured{tid: gettid(), err: err}
+			<-release
+			// an unconfined thread is never reused, let the scheduler kill it
+			if err == nil {
+				runtime.UnlockOSThread()
+			}
+		}()
+		c := <-results
+		fresh = !pending[c.tid]
+		delete(pending, c.tid)
+		if c.err != nil && firstErr == nil {
+			firstErr = c.err
+		}
+	}
+
+	err = verifyProcess(profile, target, check, firstErr)
+	close(release)
+	return err
+}
+
+// verifyProcess checks that every thread of the process carries target.
+func verifyProcess(profile string, target Label, check func(cur Label, target Label) bool, transitionErr error) error {
+	tids, err := listTasks()
+	if err != nil {
+		return err
+	}
+
+	confineErr := &ProcessConfineError{Profile: profile, Threads: make(map[int]string), Err: transitionErr}
+	for _, tid := range tids {
+		curLabel, _, err := AAGetTaskConLabel(tid)
+		if os.IsNotExist(err) {
+			// the thread exited
+			continue
+		} else if err != nil {
+			confineErr.Threads[tid] = err.Error()
+		} else if !check(curLabel, target) {
+			confineErr.Threads[tid] = curLabel.String()
+		}
+	}
+	if len(confineErr.Threads) > 0 {
+		return confineErr
+	}
+	return nil
+}
+
+// listTasks returns the tids of all threads of the current process.
+func listTasks() ([]int, error) {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		tids = append(tids, tid)
+	}
+	return tids, nil
+}
@@ -0,0 +1,34 @@
+package apparmor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTasks(t *testing.T) {
+	tids, err := listTasks()
+	require.NoError(t, err)
+	assert.Contains(t, tids, gettid())
+}
+
+func TestConfineProcessInvalid(t *testing.T) {
+	assert.Error(t, ConfineProcess(""))
+	assert.Error(t, StackProcess(""))
+}
+
+func TestProcessConfineError(t *testing.T) {
+	cause := errors.New("cause")
+	err := &ProcessConfineError{
+		Profile: "test-profile",
+		Threads: map[int]string{
+			12: "unconfined",
+			3:  "test-profile//test-hat",
+		},
+		Err: cause,
+	}
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, `cannot confine 2 threads to "test-profile": 3="test-profile//test-hat" 12="unconfined": cause`, err.Error())
+}
@@ -0,0 +1,119 @@
+//go:build linux
+package apparmor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"syscall"
+)
+
+// Mediation classes of a label query
+const (
+	AAClassFile = 2
+	AAClassDBus = 32
+)
+
+// Permission bits of a label query, as defined in libapparmor
+const (
+	AAMayExec          uint32 = 1 << 0
+	AAMayWrite         uint32 = 1 << 1
+	AAMayRead          uint32 = 1 << 2
+	AAMayAppend        uint32 = 1 << 3
+	AAMayCreate        uint32 = 1 << 4
+	AAMayDelete        uint32 = 1 << 5
+	AAMayOpen          uint32 = 1 << 6
+	AAMayRename        uint32 = 1 << 7
+	AAMaySetattr       uint32 = 1 << 8
+	AAMayGetattr       uint32 = 1 << 9
+	AAMaySetcred       uint32 = 1 << 10
+	AAMayGetcred       uint32 = 1 << 11
+	AAMayChmod         uint32 = 1 << 12
+	AAMayChown         uint32 = 1 << 13
+	AAMayLock          uint32 = 0x8000
+	AAExecMmap         uint32 = 0x10000
+	AAMayLink          uint32 = 0x40000
+	AAMayOnexec        uint32 = 0x20000000
+	AAMayChangeProfile uint32 = 0x40000000
+)
+
+// queryCmdLabel prefixes every label query written to .access, including the NUL separator
+const queryCmdLabel = "label\x00"
+
+// AAQueryLabel asks the kernel whether the label at the start of query is allowed
+// the permissions in mask, and whether the access would be audited.
+//
+// query is the label followed by a NUL separator, the mediation class and the
+// class specific data. Unlike aa_query_label() in libapparmor, query must not
+// be prefixed with room for the query command.
+func AAQueryLabel(mask uint32, query []byte) (allowed bool, audited bool, err error) {
+	mnt, err := findMountPoint()
+	if err != nil {
+		return false, false, err
+	}
+	return queryLabel(mnt, mask, query)
+}
+
+func queryLabel(mnt string, mask uint32, query []byte) (allowed bool, audited bool, err error) {
+	f, err := os.OpenFile(path.Join(mnt, ".access"), os.O_RDWR, 0)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+
+	// .access is a transaction file, the response is read from the same file descriptor
+	if _, err := f.Write(append([]byte(queryCmdLabel), query...)); err != nil {
+		return false, false, unwrapPathError(err)
+	}
+	resp, err := io.ReadAll(f)
+	if err != nil {
+		return false, false, unwrapPathError(err)
+	}
+	return parseQueryResponse(mask, string(resp))
+}
+
+// parseQueryResponse parses the response to a label query, following aa_query_label() in libapparmor
+func parseQueryResponse(mask uint32, resp string) (allowed bool, audited bool, err error) {
+	var allow, deny, audit, quiet uint32
+	if n, err := fmt.Sscanf(resp, "allow 0x%x\ndeny 0x%x\naudit 0x%x\nquiet 0x%x\n",
+		&allow, &deny, &audit, &quiet); err != nil || n != 4 {
+		return false, false, fmt.Errorf("cannot parse query response %q: %w", resp, syscall.EPROTONOSUPPORT)
+	}
+
+	allowed = mask&^(allow&^deny) == 0
+	if !allowed {
+		// denials are audited unless quieted
+		audit = ^uint32(0)
+	}
+	audited = mask&^(audit&^quiet) == 0
+	return allowed, audited, nil
+}
+
+// fileQuery builds the query for AAQueryFilePath().
+func fileQuery(label string, path string) []byte {
+	query := make([]byte, 0, len(label)+len(path)+2)
+	query = append(query, label...)
+	query = append(query, 0, AAClassFile)
+	return append(query, path...)
+}
+
+// linkQuery builds the query for AAQueryLinkPath().
+func linkQuery(label string, target string, link string) []byte {
+	query := fileQuery(label, link)
+	query = append(query, 0)
+	return append(query, target...)
+}
+
+// AAQueryFilePath asks the kernel whether label is allowed the file permissions in mask on path.
+// functional replica of aa_query_file_path() in libapparmor
+func AAQueryFilePath(mask uint32, label string, path string) (allowed bool, audited bool, err error) {
+	return AAQueryLabel(mask, fileQuery(label, path))
+}
+
+// AAQueryLinkPath asks the kernel whether label is allowed to create a hard link
+// at link pointing to target.
+// functional replica of aa_query_link_path() in libapparmor
+func AAQueryLinkPath(label string, target string, link string) (allowed bool, audited bool, err error) {
+	return AAQueryLabel(AAMayLink, linkQuery(label, target, link))
+}
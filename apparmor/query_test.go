@@ -0,0 +1,42 @@
+package apparmor
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryBuilders(t *testing.T) {
+	assert.Equal(t, []byte("test-profile\x00\x02/etc/passwd"), fileQuery("test-profile", "/etc/passwd"))
+	assert.Equal(t, []byte("test-profile\x00\x02/tmp/link\x00/etc/passwd"), linkQuery("test-profile", "/etc/passwd", "/tmp/link"))
+}
+
+func TestParseQueryResponse(t *testing.T) {
+	resp := "allow 0x00000006\ndeny 0x00000001\naudit 0x00000004\nquiet 0x00000000\n"
+
+	allowed, audited, err := parseQueryResponse(AAMayRead, resp)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.True(t, audited)
+
+	allowed, audited, err = parseQueryResponse(AAMayRead|AAMayWrite, resp)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.False(t, audited)
+
+	// denials are audited
+	allowed, audited, err = parseQueryResponse(AAMayExec, resp)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.True(t, audited)
+
+	// unless quieted
+	allowed, audited, err = parseQueryResponse(AAMayExec, "allow 0x00000006\ndeny 0x00000001\naudit 0x00000000\nquiet 0x00000001\n")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.False(t, audited)
+
+	_, _, err = parseQueryResponse(AAMayRead, "allow 0x00000006\n")
+	assert.ErrorIs(t, err, syscall.EPROTONOSUPPORT)
+}
@@ -0,0 +1,132 @@
+//go:build linux
+package apparmor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// SelfConfineEnv is set to the target profile in the environment of a program
+// re-executed by SelfConfine(), to detect re-exec loops. It is removed from the
+// environment once the program is confined.
+const SelfConfineEnv = "GO_APPARMOR_SELF_CONFINE"
+
+// ErrSelfConfineLoop is returned by SelfConfine() when the program has already been
+// re-executed but is still not confined by the target profile.
+var ErrSelfConfineLoop = errors.New("not confined after re-exec")
+
+// SelfConfineOpts are the options for SelfConfine().
+type SelfConfineOpts struct {
+	// Stack stacks the profile on top of the current confinement using AAStackOnExec()
+	// instead of replacing it using AAChangeOnExec().
+	Stack bool
+}
+
+type selfConfineAction int
+
+const (
+	selfConfineDone selfConfineAction = iota
+	selfConfineExec
+	selfConfineLoop
+)
+
+// SelfConfine puts the program under profile by re-executing it, and should be called
+// at the beginning of main() before any real work is done.
+//
+// If the current task is already confined by profile, SelfConfine returns nil.
+// Otherwise it requests the transition with AAChangeOnExec() (or AAStackOnExec()
+// if opts.Stack is set) and re-executes os.Executable() with the same arguments and
+// environment, in which case it does not return on success.
+//
+// Unlike ConfineProcess(), which cannot reach the threads of the Go runtime that
+// never run goroutines, every thread of the re-executed program carries the new
+// label, as the transition happens before the runtime starts.
+//
+// An error is returned if the kernel refuses the transition or the program cannot be
+// re-executed, and ErrSelfConfineLoop if the re-executed program is still not confined,
+// for example because profile is in a different namespace.
+func SelfConfine(profile string, opts *SelfConfineOpts) error {
+	if profile == "" {
+		return errors.New("SelfConfine() does not accept empty string")
+	}
+	if opts == nil {
+		opts = &SelfConfineOpts{}
+	}
+	target, err := ParseLabel(profile)
+	if err != nil {
+		return err
+	}
+
+	curLabel, _, err := AAGetConLabel()
+	if err != nil {
+		return fmt.Errorf("cannot get current label: %v", err)
+	}
+
+	envValue, envSet := os.LookupEnv(SelfConfineEnv)
+	switch selfConfineNext(curLabel, target, opts.Stack, envSet && envValue == profile) {
+	case selfConfineDone:
+		if envSet {
+			os.Unsetenv(SelfConfineEnv)
+		}
+		return nil
+	case selfConfineLoop:
+		return fmt.Errorf("cannot self confine to %q: %w, current label is %q", profile, ErrSelfConfineLoop, curLabel)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot self confine to %q: %v", profile, err)
+	}
+	env := append(removeEnv(os.Environ(), SelfConfineEnv), SelfConfineEnv+"="+profile)
+
+	wait := make(chan error, 1)
+	go func() {
+		// the exec attr is per-thread and cannot be cleared,
+		// never unlock the thread so it is killed if the exec fails
+		runtime.LockOSThread()
+		var err error
+		if opts.Stack {
+			err = AAStackOnExec(profile)
+		} else {
+			err = AAChangeOnExec(profile)
+		}
+		if err != nil {
+			wait <- err
+			return
+		}
+		wait <- fmt.Errorf("cannot re-execute %q: %v", exe, syscall.Exec(exe, os.Args, env))
+	}()
+	return <-wait
+}
+
+// selfConfineNext decides what SelfConfine() does given the current label and whether
+// the program was already re-executed for the same profile.
+func selfConfineNext(cur Label, target Label, stack bool, reexecuted bool) selfConfineAction {
+	confined := cur.Equal(target)
+	if stack {
+		confined = cur.Contains(target)
+	}
+	switch {
+	case confined:
+		return selfConfineDone
+	case reexecuted:
+		return selfConfineLoop
+	default:
+		return selfConfineExec
+	}
+}
+
+// removeEnv returns env without the entries for key.
+func removeEnv(env []string, key string) []string {
+	ret := make([]string, 0, len(env))
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			ret = append(ret, kv)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,30 @@
+package apparmor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfConfineNext(t *testing.T) {
+	target := mustParseLabel("test-profile")
+
+	assert.Equal(t, selfConfineExec, selfConfineNext(mustParseLabel("unconfined"), target, false, false))
+	assert.Equal(t, selfConfineLoop, selfConfineNext(mustParseLabel("unconfined"), target, false, true))
+	assert.Equal(t, selfConfineDone, selfConfineNext(target, target, false, false))
+	assert.Equal(t, selfConfineDone, selfConfineNext(target, target, false, true))
+
+	stacked := mustParseLabel("other-profile//&test-profile")
+	assert.Equal(t, selfConfineExec, selfConfineNext(stacked, target, false, false))
+	assert.Equal(t, selfConfineDone, selfConfineNext(stacked, target, true, true))
+	assert.Equal(t, selfConfineExec, selfConfineNext(mustParseLabel("other-profile"), target, true, false))
+}
+
+func TestRemoveEnv(t *testing.T) {
+	env := []string{"A=1", SelfConfineEnv + "=test-profile", SelfConfineEnv + "_OTHER=1", "B="}
+	assert.Equal(t, []string{"A=1", SelfConfineEnv + "_OTHER=1", "B="}, removeEnv(env, SelfConfineEnv))
+}
+
+func TestSelfConfineInvalid(t *testing.T) {
+	assert.Error(t, SelfConfine("", nil))
+}
@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/eternal-flame-AD/go-apparmor/internal/apparmor_c"
 	"github.com/stretchr/testify/assert"
@@ -117,16 +118,14 @@ func TestWithShim(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, labelExp, label)
 	assert.Equal(t, modeExp, mode)
-	/*
-		rawconn, err := sockConn.SyscallConn()
-		require.NoError(t, err)
-		rawconn.Control(func(fd uintptr) {
-			labelByConn, modeByConn, err := AAGetPeerCon(int(fd))
-			assert.NoError(t, err)
-			assert.Equal(t, labelExp, labelByConn)
-			assert.Equal(t, modeExp, modeByConn)
-		})
-	*/
+	labelByConn, modeByConn, err := UnixPeerCon(sockConn)
+	if err == syscall.ENOPROTOOPT {
+		t.Logf("aa_getpeercon returned ENOPROTOOPT, skipping")
+	} else {
+		assert.NoError(t, err)
+		assert.Equal(t, labelExp, labelByConn.String())
+		assert.Equal(t, ModeUnconfined, modeByConn)
+	}
 
 	writeCmd(ShimCmd{
 		Command: "generate_profile",
@@ -153,6 +152,48 @@ func TestWithShim(t *testing.T) {
 	})
 	sockConn.Close()
 
+	{
+		// start the shim under the child profile instead of the attached profile
+		cmd := CommandWithProfile("test-profile//test-subprofile", shimExecPath, sockAddr)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		require.NoError(t, cmd.Start())
+		conn, err := sockListener.Accept()
+		require.NoError(t, err)
+		sockConn = conn.(*net.UnixConn)
+		shimInput, shimOutput = json.NewEncoder(conn), json.NewDecoder(conn)
+
+		writeCmd(ShimCmd{
+			Command: "getcon",
+		})
+		resp = readResp()
+		labelExp, modeExp = resp.Response[0], resp.Response[1]
+		require.Equal(t, "test-profile//test-subprofile", labelExp)
+		require.Equal(t, "enforce", modeExp)
+		label, mode, err = AAGetTaskCon(cmd.Process().Pid)
+		assert.NoError(t, err)
+		assert.Equal(t, labelExp, label)
+		assert.Equal(t, modeExp, mode)
+
+		// the thread that started the shim is retired, no thread is left with the exec attr set
+		assert.Eventually(t, func() bool {
+			tids, err := listTasks()
+			require.NoError(t, err)
+			for _, tid := range tids {
+				if _, _, err := AAGetTaskExecCon(tid); err != ErrAttrNotSet {
+					return false
+				}
+			}
+			return true
+		}, time.Second, 10*time.Millisecond)
+
+		writeCmd(ShimCmd{
+			Command: "exit",
+		})
+		sockConn.Close()
+		cmd.Wait()
+	}
+
 	testTransitions := func(usegoapi bool) {
 		// start shim again
 		shimInput, shimOutput = runShim()
@@ -177,11 +218,15 @@ func TestWithShim(t *testing.T) {
 		assert.Equal(t, labelExp, label)
 		assert.Equal(t, modeExp, mode)
 
-		sockConnF, err := sockConn.File()
+		rawConn, err := sockConn.SyscallConn()
 		require.NoError(t, err)
 
-		labelByConn, modeByConn, errGo := AAGetPeerCon(int(sockConnF.Fd()))
-		labelC, modeC, errC := apparmor_c.AAGetPeerConC(int(sockConnF.Fd()))
+		labelByConn, modeByConn, errGo := PeerConOf(sockConn)
+		var labelC, modeC string
+		var errC error
+		require.NoError(t, rawConn.Control(func(fd uintptr) {
+			labelC, modeC, errC = apparmor_c.AAGetPeerConC(int(fd))
+		}))
 
 		if errGo != errC {
 			t.Fatalf("go and c error mismatch: %v != %v", errGo, errC)
@@ -191,14 +236,13 @@ func TestWithShim(t *testing.T) {
 			assert.Equal(t, modeExp, modeC)
 
 			assert.NoError(t, err)
-			assert.Equal(t, labelExp, labelByConn)
-			assert.Equal(t, modeExp, modeByConn)
+			assert.Equal(t, labelExp, labelByConn.String())
+			assert.Equal(t, modeExp, modeByConn.String())
 		} else if errGo == syscall.ENOPROTOOPT {
 			t.Logf("aa_getpeercon returned ENOPROTOOPT, skipping")
 		} else {
 			t.Fatalf("unexpected error: %v", errGo)
 		}
-		sockConnF.Close()
 
 		writeCmd(ShimCmd{
 			Command:  "change_hat",
@@ -239,6 +283,40 @@ func TestWithShim(t *testing.T) {
 		assert.Equal(t, labelExp, label)
 		assert.Equal(t, modeExp, mode)
 
+		writeCmd(ShimCmd{
+			Command:  "change_hat_v",
+			UseGoAPI: usegoapi,
+			Args:     []string{"12345", "nonexistent-hat", "test-hat"},
+		})
+		_ = readResp()
+
+		writeCmd(ShimCmd{
+			Command: "getcon",
+		})
+		resp = readResp()
+		labelExp, modeExp = resp.Response[0], resp.Response[1]
+		require.Equal(t, "test-profile//test-hat", labelExp)
+		require.Equal(t, "enforce", modeExp)
+		label, mode, err = AAGetTaskCon(shimTid)
+		assert.NoError(t, err)
+		assert.Equal(t, labelExp, label)
+		assert.Equal(t, modeExp, mode)
+
+		writeCmd(ShimCmd{
+			Command:  "change_hat_v",
+			UseGoAPI: usegoapi,
+			Args:     []string{"12345"},
+		})
+		_ = readResp()
+
+		writeCmd(ShimCmd{
+			Command: "getcon",
+		})
+		resp = readResp()
+		labelExp, modeExp = resp.Response[0], resp.Response[1]
+		require.Equal(t, "test-profile", labelExp)
+		require.Equal(t, "complain", modeExp)
+
 		writeCmd(ShimCmd{
 			Command:  "change_profile",
 			UseGoAPI: usegoapi,
@@ -3,9 +3,68 @@ package apparmor
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"runtime"
+	"strings"
+	"syscall"
 )
 
+// unsupportedError is returned when the running kernel lacks a feature.
+// It matches syscall.ENOTSUP with errors.Is().
+type unsupportedError struct {
+	msg string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.msg
+}
+
+func (e *unsupportedError) Is(target error) bool {
+	return target == syscall.ENOTSUP
+}
+
+var (
+	ErrChangeHatNotSupported     error = &unsupportedError{"change_hat is not supported by the kernel"}
+	ErrChangeHatVNotSupported    error = &unsupportedError{"change_hatv is not supported by the kernel"}
+	ErrChangeProfileNotSupported error = &unsupportedError{"change_profile is not supported by the kernel"}
+	ErrChangeOnExecNotSupported  error = &unsupportedError{"change_onexec is not supported by the kernel"}
+	ErrStackingNotSupported      error = &unsupportedError{"stacking is not supported by the kernel"}
+)
+
+// writeTransition writes cmd to the proc attr of the current task,
+// wrapping failures in a *TransitionError.
+func writeTransition(op string, target string, attr string, cmd string) error {
+	// the write and the label read for the error must happen on the same thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tid := gettid()
+	if err := SetProcAttr(tid, attr, cmd); err != nil {
+		return newTransitionError(op, target, tid, err)
+	}
+	return nil
+}
+
+// checkKernelFeature returns errUnsupported if the running kernel is known not to
+// support feature.
+//
+// If the kernel features cannot be read, e.g. because the task is confined without
+// access to securityfs, the check passes and the transition is left to the kernel.
+func checkKernelFeature(feature string, errUnsupported error) error {
+	features, err := getKernelFeatures()
+	if err != nil {
+		return nil
+	}
+	return checkFeature(features, feature, errUnsupported)
+}
+
+func checkFeature(features *Features, feature string, errUnsupported error) error {
+	if !features.Supports(feature) {
+		return errUnsupported
+	}
+	return nil
+}
+
 // AAChangeHat transitions the current task to the specified hat.
 // functional replica of aa_change_hat() in libapparmor
 func AAChangeHat(hat string, token uint64) error {
@@ -17,63 +76,100 @@ func AAChangeHat(hat string, token uint64) error {
 		return fmt.Errorf("invalid hat(%s): too long", hat)
 	}
 
+	if err := checkKernelFeature("domain/change_hat", ErrChangeHatNotSupported); err != nil {
+		return err
+	}
+
 	// const char *fmt = "changehat %016lx^%s";
-	return SetProcAttr(gettid(), "current",
+	return writeTransition(OpChangeHat, hat, "current",
 		fmt.Sprintf("changehat %016x^%s", token, hat))
 }
 
 // AAChangeProfile transitions the current task to the specified profile.
 // functional replica of aa_change_profile() in libapparmor
 func AAChangeProfile(profile string) error {
+	if err := checkKernelFeature("domain/change_profile", ErrChangeProfileNotSupported); err != nil {
+		return err
+	}
 	// len = asprintf(&buf, "changeprofile %s", profile);
-	return SetProcAttr(gettid(), "current", fmt.Sprintf("changeprofile %s", profile))
+	return writeTransition(OpChangeProfile, profile, "current", fmt.Sprintf("changeprofile %s", profile))
 }
 
 // AAChangeOnExec transitions the current task to the specified profile on next exec() call.
 // functional replica of aa_change_onexec() in libapparmor
 func AAChangeOnExec(profile string) error {
+	if err := checkKernelFeature("domain/change_onexec", ErrChangeOnExecNotSupported); err != nil {
+		return err
+	}
 	// len = asprintf(&buf, "exec %s", profile);
-	return SetProcAttr(gettid(), "exec", fmt.Sprintf("exec %s", profile))
+	return writeTransition(OpChangeOnExec, profile, "exec", fmt.Sprintf("exec %s", profile))
 }
 
-// privAAChangeHatV is a replica of aa_change_hat_v() in libapparmor
-// not clearly defined in documentation, thus not exported
-func privAAChangeHatV(subprofiles []string, token uint64) error {
+// AAChangeHatV transitions the current task to the first hat in hats that exists
+// in the current profile, or back to the parent profile if hats is empty.
+// functional replica of aa_change_hatv() in libapparmor
+//
+// On kernels without change_hatv support, it falls back to change_hat if at most
+// one hat is given and returns ErrChangeHatVNotSupported otherwise.
+func AAChangeHatV(hats []string, token uint64) error {
+	if token == 0 {
+		return fmt.Errorf("invalid token(%d): must not be zero", token)
+	}
+
+	for _, hat := range hats {
+		if hat == "" || strings.IndexByte(hat, 0) >= 0 {
+			return fmt.Errorf("invalid hat(%q): %w", hat, syscall.EINVAL)
+		}
+		if len(hat) > 4096 {
+			return fmt.Errorf("invalid hat(%s): too long: %w", hat, syscall.EPROTO)
+		}
+	}
+
+	if err := checkKernelFeature("domain/change_hatv", ErrChangeHatVNotSupported); err != nil {
+		switch len(hats) {
+		case 0:
+			return AAChangeHat("", token)
+		case 1:
+			return AAChangeHat(hats[0], token)
+		}
+		return err
+	}
+
 	/* setup command string which is of the form
 	 * changehat <token>^hat1\0hat2\0hat3\0..\0
 	 */
 	var cmdBytes bytes.Buffer
-	if _, err := cmdBytes.WriteString(fmt.Sprintf("changehat %016x^", token)); err != nil {
-		return err
-	}
-	if len(subprofiles) > 0 {
-		for _, subprofile := range subprofiles {
-			if _, err := cmdBytes.WriteString(subprofile); err != nil {
-				return err
-			}
-
-			if err := cmdBytes.WriteByte(0); err != nil {
-				return err
-			}
+	cmdBytes.WriteString(fmt.Sprintf("changehat %016x^", token))
+	if len(hats) > 0 {
+		for _, hat := range hats {
+			cmdBytes.WriteString(hat)
+			cmdBytes.WriteByte(0)
 		}
 	} else {
-		if err := cmdBytes.WriteByte(0); err != nil {
-			return err
-		}
+		// step past trailing \0
+		cmdBytes.WriteByte(0)
 	}
-	return SetProcAttr(gettid(), "current", cmdBytes.String())
+	return writeTransition(OpChangeHatV, strings.Join(hats, ","), "current", cmdBytes.String())
 }
 
 // AAStackProfile stacks the specified profile onto the current task confinement.
 // functional replica of aa_stack_profile() in libapparmor
 func AAStackProfile(profile string) error {
-	return SetProcAttr(gettid(), "stack", fmt.Sprintf("stack %s", profile))
+	if err := checkKernelFeature("domain/stack", ErrStackingNotSupported); err != nil {
+		return err
+	}
+	// len = asprintf(&buf, "stack %s", profile);
+	// the kernel has no stack attr, the command is written to current like change_profile
+	return writeTransition(OpStackProfile, profile, "current", fmt.Sprintf("stack %s", profile))
 }
 
 // AAStackOnExec stacks the specified profile onto the current task confinement on next exec() call.
-// functional replica of aa_get_onexec() in libapparmor
+// functional replica of aa_stack_onexec() in libapparmor
 func AAStackOnExec(profile string) error {
-	return SetProcAttr(gettid(), "exec", fmt.Sprintf("stack %s", profile))
+	if err := checkKernelFeature("domain/stack", ErrStackingNotSupported); err != nil {
+		return err
+	}
+	return writeTransition(OpStackOnExec, profile, "exec", fmt.Sprintf("stack %s", profile))
 }
 
 // AAGetTaskCon returns the confinement label and mode of the specified task.
@@ -85,3 +181,63 @@ func AAGetTaskCon(pid int) (label string, mode string, err error) {
 func AAGetCon() (label string, mode string, err error) {
 	return AAGetTaskCon(gettid())
 }
+
+// AAGetTaskPrevCon returns the confinement label and mode the specified task
+// had before entering its current hat.
+//
+// ErrAttrNotSet is returned if the task is not in a hat.
+func AAGetTaskPrevCon(pid int) (label string, mode string, err error) {
+	return splitOptionalCon(GetProcAttrRaw(pid, "prev"))
+}
+
+// AAGetPrevCon returns the confinement label and mode the current task
+// had before entering its current hat.
+//
+// ErrAttrNotSet is returned if the task is not in a hat.
+func AAGetPrevCon() (label string, mode string, err error) {
+	return AAGetTaskPrevCon(gettid())
+}
+
+// AAGetTaskExecCon returns the confinement label and mode the specified task will
+// transition to on its next exec() call, as set by AAChangeOnExec() or AAStackOnExec().
+//
+// ErrAttrNotSet is returned if no exec transition is pending.
+func AAGetTaskExecCon(pid int) (label string, mode string, err error) {
+	return splitOptionalCon(GetProcAttrRaw(pid, "exec"))
+}
+
+// AAGetExecCon returns the confinement label and mode the current task will
+// transition to on its next exec() call, as set by AAChangeOnExec() or AAStackOnExec().
+//
+// ErrAttrNotSet is returned if no exec transition is pending.
+func AAGetExecCon() (label string, mode string, err error) {
+	return AAGetTaskExecCon(gettid())
+}
+
+// splitOptionalCon splits the content of the prev or exec attr,
+// which the kernel refuses to read with EINVAL or returns empty when not set.
+func splitOptionalCon(raw string, err error) (label string, mode string, _ error) {
+	if errors.Is(err, syscall.EINVAL) {
+		return "", "", ErrAttrNotSet
+	} else if err != nil {
+		return "", "", err
+	}
+	if strings.TrimRight(raw, "\x00\n") == "" {
+		return "", "", ErrAttrNotSet
+	}
+	return SplitCon(strings.TrimRight(raw, "\x00"))
+}
+
+// AAGetTaskConLabel returns the parsed confinement label and mode of the specified task.
+func AAGetTaskConLabel(pid int) (label Label, mode Mode, err error) {
+	r, err := GetProcAttrRaw(pid, "current")
+	if err != nil {
+		return Label{}, "", err
+	}
+	return parseCon(r)
+}
+
+// AAGetConLabel returns the parsed confinement label and mode of the current task.
+func AAGetConLabel() (label Label, mode Mode, err error) {
+	return AAGetTaskConLabel(gettid())
+}
@@ -0,0 +1,66 @@
+package apparmor
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFeature(t *testing.T) {
+	withStack, err := NewFeaturesFromString("domain {change_hat {yes\n}\nstack {yes\n}\n}\n")
+	require.NoError(t, err)
+	withoutStack, err := NewFeaturesFromString("domain {change_hat {yes\n}\n}\n")
+	require.NoError(t, err)
+
+	assert.NoError(t, checkFeature(withStack, "domain/stack", ErrStackingNotSupported))
+	err = checkFeature(withoutStack, "domain/stack", ErrStackingNotSupported)
+	assert.ErrorIs(t, err, ErrStackingNotSupported)
+	assert.ErrorIs(t, err, syscall.ENOTSUP)
+	assert.False(t, errors.Is(err, ErrChangeHatVNotSupported))
+	assert.ErrorIs(t, checkFeature(withStack, "domain/change_hatv", ErrChangeHatVNotSupported), ErrChangeHatVNotSupported)
+}
+
+func TestAAChangeHatVValidation(t *testing.T) {
+	assert.Error(t, AAChangeHatV([]string{"hat"}, 0))
+	assert.Error(t, AAChangeHatV(nil, 0))
+	assert.ErrorIs(t, AAChangeHatV([]string{"hat", ""}, 1), syscall.EINVAL)
+	assert.ErrorIs(t, AAChangeHatV([]string{"hat\x00other"}, 1), syscall.EINVAL)
+	assert.ErrorIs(t, AAChangeHatV([]string{strings.Repeat("a", 4097)}, 1), syscall.EPROTO)
+}
+
+func TestSplitOptionalCon(t *testing.T) {
+	pathErr := &os.PathError{Op: "read", Path: "/proc/1/attr/apparmor/exec", Err: syscall.EINVAL}
+	_, _, err := splitOptionalCon("", pathErr)
+	assert.ErrorIs(t, err, ErrAttrNotSet)
+	_, _, err = splitOptionalCon("\n", nil)
+	assert.ErrorIs(t, err, ErrAttrNotSet)
+
+	pathErr = &os.PathError{Op: "open", Path: "/proc/1/attr/apparmor/exec", Err: syscall.EACCES}
+	_, _, err = splitOptionalCon("", pathErr)
+	assert.ErrorIs(t, err, syscall.EACCES)
+	assert.NotErrorIs(t, err, ErrAttrNotSet)
+
+	label, mode, err := splitOptionalCon("test-profile (enforce)\n", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-profile", label)
+	assert.Equal(t, "enforce", mode)
+}
+
+func TestStackProfileAttr(t *testing.T) {
+	// stack commands are accepted by the current attr, there is no stack attr to write to
+	for _, dir := range []string{"/proc/thread-self/attr/apparmor", "/proc/thread-self/attr"} {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		_, err := os.Stat(path.Join(dir, "current"))
+		assert.NoError(t, err, dir)
+		_, err = os.Stat(path.Join(dir, "stack"))
+		assert.True(t, os.IsNotExist(err), dir)
+	}
+}
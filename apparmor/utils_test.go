@@ -30,3 +30,11 @@ func poll(timeout time.Duration, fn func() (bool, error)) error {
 		time.Sleep(time.Millisecond * 100)
 	}
 }
+
+func mustParseLabel(raw string) Label {
+	label, err := ParseLabel(raw)
+	if err != nil {
+		panic(err)
+	}
+	return label
+}
@@ -110,14 +110,12 @@ func main() {
 				resp.ErrorStr = err.Error()
 			}
 			if cmd.UseGoAPI {
-				/*
-					err = apparmor.AAChangeHatV(cmd.Args[1:], magicToken)
-					if err != nil {
-						resp.ErrorStr = err.Error()
-					} else {
-						resp.Success = true
-					}*/
-				resp.ErrorStr = "not implemented"
+				err = apparmor.AAChangeHatV(cmd.Args[1:], magicToken)
+				if err != nil {
+					resp.ErrorStr = err.Error()
+				} else {
+					resp.Success = true
+				}
 			} else {
 				err = apparmor_c.AAChangeHatVC(cmd.Args[1:], magicToken)
 				if err != nil {
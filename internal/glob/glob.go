@@ -0,0 +1,75 @@
+// Package glob matches AppArmor labels, profile names and modes against glob patterns.
+//
+// The syntax follows AppArmor's file globbing where it makes sense for labels:
+//
+//   - "*" matches any sequence of characters except '/'
+//   - "**" matches any sequence of characters including '/'
+//   - "?" matches any single character except '/'
+//   - "\\" escapes the following character
+//
+// So "profile//*" matches a hat of profile and "profile//**" any of its hats and
+// children. Note that "profile**" also matches every profile whose name starts with
+// profile, such as "profile-untrusted", so it does not select a profile and its children.
+package glob
+
+import "errors"
+
+// ErrBadPattern is returned by Validate for a pattern ending in a lone backslash.
+var ErrBadPattern = errors.New("syntax error in pattern")
+
+// Validate checks pattern for syntax errors.
+func Validate(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' {
+			if i+1 == len(pattern) {
+				return ErrBadPattern
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+// Match reports whether name matches pattern. Malformed patterns never match.
+func Match(pattern string, name string) bool {
+	if Validate(pattern) != nil {
+		return false
+	}
+	return match(pattern, name)
+}
+
+func match(pattern string, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			crossSlash := len(pattern) > 1 && pattern[1] == '*'
+			rest := pattern[1:]
+			if crossSlash {
+				rest = pattern[2:]
+			}
+			for i := 0; i <= len(name); i++ {
+				if match(rest, name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' && !crossSlash {
+					return false
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 || name[0] == '/' {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		case '\\':
+			pattern = pattern[1:]
+			fallthrough
+		default:
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,48 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"test-profile", "test-profile", true},
+		{"test-profile", "test-profile//hat", false},
+		{"test-*", "test-profile", true},
+		{"test-*", "test-profile//hat", false},
+		{"test-profile//*", "test-profile//hat", true},
+		{"test-profile//*", "test-profile", false},
+		{"test-profile**", "test-profile", true},
+		{"test-profile**", "test-profile//hat//child", true},
+		{"test-profile**", "test-profile-untrusted", true},
+		{"test-profile//**", "test-profile//hat//child", true},
+		{"test-profile//**", "test-profile", false},
+		{"/usr/bin/*", "/usr/bin/cat", true},
+		{"/usr/bin/*", "/usr/bin/x/cat", false},
+		{"/usr/**", "/usr/bin/x/cat", true},
+		{"/usr/**/cat", "/usr/bin/x/cat", true},
+		{"/usr/**/cat", "/usr/bin/x/dog", false},
+		{"c?mplain", "complain", true},
+		{"c?mplain", "c/mplain", false},
+		{"?", "", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\`, "a", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, Match(c.pattern, c.name), "Match(%q, %q)", c.pattern, c.name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(`a\*`))
+	assert.ErrorIs(t, Validate(`a\`), ErrBadPattern)
+}
@@ -112,6 +112,12 @@ func main() {
 		panic(err)
 	}
 	if label == "unconfined" {
+		if loaded, err := apparmor.IsProfileLoaded(ProfileName); err == nil && loaded {
+			// does not return on success
+			if err := apparmor.SelfConfine(ProfileName, nil); err != nil {
+				log.Panicf("Failed to confine to profile %s: %v", ProfileName, err)
+			}
+		}
 		tplCtx := TplContext{
 			ProfileName: ProfileName,
 			TestDir:     os.TempDir(),
@@ -130,7 +136,7 @@ func main() {
 		}
 		log.Printf("Created profile %s", ProfileName)
 	} else if label == ProfileName {
-		if mode != "enforce" {
+		if apparmor.ParseMode(mode) != apparmor.ModeEnforce {
 			log.Panicf("Wrong mode: %s", mode)
 		}
 		log.Printf("Running in profile %s", ProfileName)